@@ -9,7 +9,9 @@ const lastTimestamp = {};
 
 let abci;
 
-setTimeout(start, 2000); // Wait for the tendermint node and abci to start up and connect to eachother
+if (require.main === module) {
+  setTimeout(start, 2000); // Wait for the tendermint node and abci to start up and connect to eachother
+}
 function start() {
   const binanceBTCUSDT = new WebSocket("wss://data-stream.binance.vision/ws/btcusdt@aggTrade");
   const binanceETHUSDT = new WebSocket("wss://data-stream.binance.vision/ws/ethusdt@aggTrade");
@@ -44,14 +46,15 @@ function start() {
     ...openstaking,
     eventName: "TokensStaked",
     onLogs: (logs) => {
-      const {
-        transactionHash,
-        args: { account, amount },
-      } = logs[0];
+      for (const log of logs) {
+        const {
+          args: { account, amount },
+        } = log;
 
-      console.log(account, "staked", amount);
+        console.log(account, "staked", amount);
 
-      handleStake(transactionHash, account, amount);
+        handleStake(log, account, amount);
+      }
     },
     onError: (error) => {
       console.error("OpenStaking watch error", error);
@@ -68,37 +71,62 @@ function start() {
     eventName: "Transfer",
     args: { from: "0x0000000000000000000000000000000000000000" },
     onLogs: (logs) => {
-      const {
-        transactionHash,
-        args: { to },
-      } = logs[0];
-      const account = to;
-      const amount = BigInt(10_000) * BigInt(10) ** BigInt(18);
+      for (const log of logs) {
+        const {
+          args: { to },
+        } = log;
+        const account = to;
+        const amount = BigInt(10_000) * BigInt(10) ** BigInt(18);
 
-      console.log(account, "early staked", amount);
+        console.log(account, "early staked", amount);
 
-      handleStake(transactionHash, account, amount);
+        handleStake(log, account, amount);
+      }
     },
     onError: (error) => {
       console.error("ValidatorPass watch error", error);
     },
   });
-}
 
-function handleStake(transactionHash, account, amount) {
-  const json = JSON.stringify({
-    MessageType: 1, // Add verified deposit
-    TransactionHash: transactionHash,
-    DepositInfo: {
-      Address: account,
-      // Send over amount in 2 integers? Doing this here is a bit unintuative (but doing in the app reduces precision)
-      Amount: Number(amount / BigInt(10) ** BigInt(9)), // Risky!
+  // Deposits are attested once enough blocks are mined on top of them
+  client.watchBlockNumber({
+    onBlockNumber: (blockNumber) => {
+      sendToAbci({ MessageType: 2, BlockNumber: Number(blockNumber) }, "head"); // Latest block
+    },
+    onError: (error) => {
+      console.error("Block number watch error", error);
     },
   });
-  const message = [...Buffer.from(json)];
+}
+
+function handleStake(log, account, amount) {
+  if (log.removed) {
+    console.log(log.transactionHash, "removed by a reorg of block", log.blockHash);
+    sendToAbci({ MessageType: 3, TransactionHash: log.transactionHash, BlockHash: log.blockHash }, "reorg"); // Remove deposit
+    return;
+  }
+
+  sendToAbci(
+    {
+      MessageType: 1, // Add verified deposit
+      TransactionHash: log.transactionHash,
+      DepositInfo: {
+        Address: account,
+        Amount: amount.toString(), // Decimal uint256, the app uses the same 18 decimals as the ERC20
+        BlockNumber: Number(log.blockNumber),
+        BlockHash: log.blockHash,
+        LogIndex: Number(log.logIndex),
+      },
+    },
+    "deposit"
+  );
+}
+
+function sendToAbci(json, kind) {
+  const message = [...Buffer.from(JSON.stringify(json))];
   abci.send(message, (err) => {
     if (err) {
-      console.error("deposit communcication error", err);
+      console.error(kind, "communcication error", err);
       return;
     }
   });
@@ -131,13 +159,11 @@ function handleMessage(data) {
       // Two nodes generating the same transaction is no problem, just a possible waste of bandwith
       if (nodeAddress == "192.166.10.2") {
         setTimeout(async () => {
-          const json = JSON.stringify({
-            TransactionType: 0, // Try to reach consensus on data
-            DataFeed: "Binance|" + info.s + "|price", // Source|Item|property ? Decide a nice format lol
-            DataValue: price,
-            DataTimestamp: timestamp,
-          });
-          const tx = [...Buffer.from(json)];
+          const tx = encodeValidateDataTx(
+            "Binance|" + info.s + "|price", // Source|Item|property ? Decide a nice format lol
+            price,
+            timestamp
+          );
           const url = "http://" + nodeAddress + ":26657/broadcast_tx_sync?tx=0x" + toHexString(tx);
           try {
             console.log("trying transaction", url, `(${price} at ${timestamp})`);
@@ -155,8 +181,69 @@ function handleMessage(data) {
   }
 }
 
+// Transaction encoding of xnode-app/txcodec: version byte followed by a protobuf Tx message (see tx.proto)
+function encodeValidateDataTx(dataFeed, dataValue, dataTimestamp) {
+  const body = [
+    ...protobufBytesField(1, Buffer.from(dataFeed)),
+    ...protobufBytesField(2, Buffer.from(dataValue)),
+    ...protobufVarintField(3, dataTimestamp),
+  ];
+  return [1, ...protobufBytesField(1, body)]; // Version 1, validate_data = 1
+}
+
+// Bytes signed by the signer of a transaction, the SignDoc message of tx.proto after its domain separator
+// Fee is a bigint, body the encoded transaction without signature
+function encodeSignDoc({ chainId, accountNumber, nonce, fee, memo, type, body }) {
+  return [
+    ...Buffer.from("xnode-app/SignDoc/v1:"),
+    ...protobufBytesField(1, Buffer.from(chainId)),
+    ...protobufVarintField(2, accountNumber),
+    ...protobufVarintField(3, nonce),
+    ...protobufBytesField(4, amountBytes(fee)),
+    ...protobufBytesField(5, Buffer.from(memo)),
+    ...protobufVarintField(6, type),
+    ...protobufBytesField(7, body),
+  ];
+}
+
+// Token amounts are big-endian bytes without leading zeros
+function amountBytes(amount) {
+  const bytes = [];
+  for (let remaining = BigInt(amount); remaining > 0n; remaining >>= 8n) {
+    bytes.unshift(Number(remaining & 0xffn));
+  }
+  return bytes;
+}
+
+function protobufVarintField(field, value) {
+  if (BigInt(value) == 0n) {
+    return []; // Default values are omitted
+  }
+  return [(field << 3) | 0, ...protobufVarint(value)];
+}
+
+function protobufBytesField(field, bytes) {
+  if (bytes.length == 0) {
+    return []; // Default values are omitted
+  }
+  return [(field << 3) | 2, ...protobufVarint(bytes.length), ...bytes];
+}
+
+function protobufVarint(value) {
+  const bytes = [];
+  let remaining = BigInt(value);
+  while (remaining >= 0x80n) {
+    bytes.push(Number(remaining & 0x7fn) | 0x80);
+    remaining >>= 7n;
+  }
+  bytes.push(Number(remaining));
+  return bytes;
+}
+
 function toHexString(bytes) {
   return Array.from(bytes, (byte) => {
     return ("0" + (byte & 0xff).toString(16)).slice(-2);
   }).join("");
 }
+
+module.exports = { encodeValidateDataTx, encodeSignDoc, toHexString };
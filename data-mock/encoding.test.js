@@ -0,0 +1,16 @@
+// Checks the transaction encoding against the vectors of xnode-app/txcodec, run with npm test
+const assert = require("node:assert");
+const test = require("node:test");
+const { encodeValidateDataTx, encodeSignDoc, toHexString } = require("./data-provider.js");
+const vectors = require("../xnode-app/txcodec/testdata/encoding_vectors.json");
+
+test("sign doc", () => {
+  const vector = vectors.signDoc;
+  const signBytes = encodeSignDoc({ ...vector, fee: BigInt(vector.fee), body: Buffer.from(vector.body, "hex") });
+  assert.strictEqual(toHexString(signBytes), vector.signBytes);
+});
+
+test("validate data transaction", () => {
+  const vector = vectors.validateData;
+  assert.strictEqual(toHexString(encodeValidateDataTx(vector.dataFeed, vector.dataValue, vector.dataTimestamp)), vector.encoded);
+});
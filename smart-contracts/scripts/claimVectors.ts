@@ -0,0 +1,60 @@
+import { ethers } from "hardhat";
+import { writeFileSync } from "fs";
+import { claimDomain, claimTypes, ClaimMessage } from "../utils/claim";
+
+// Test vectors of xnode-app/claim_test.go, signed with the default hardhat accounts
+const vectorsFile = "../xnode-app/testdata/claim_vectors.json";
+const depositorKeys = [
+  "0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80",
+  "0x59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d",
+];
+const claims: { depositor: number; message: ClaimMessage }[] = [
+  {
+    depositor: 0,
+    message: {
+      chainId: "test-chain",
+      transactionHash: "0x3d1b6a8e4b7f0c2e9a1d5f6c8b0e2a4d6f8c1e3a5b7d9f0e2c4a6b8d0f1e3a5c",
+      receiver: "0x70997970C51812dc3A010C7d01b50e0d17dc79C8",
+    },
+  },
+  {
+    depositor: 0,
+    message: {
+      chainId: "test-chain",
+      transactionHash: "0x3d1b6a8e4b7f0c2e9a1d5f6c8b0e2a4d6f8c1e3a5b7d9f0e2c4a6b8d0f1e3a5c",
+      receiver: "DEEBB9AA52F36A3FE78ABBCD0EDBC4D1623D3FCF",
+    },
+  },
+  {
+    depositor: 1,
+    message: {
+      chainId: "openmesh-1",
+      transactionHash: "0xa1f0e2d3c4b5a6978877665544332211ffeeddccbbaa99887766554433221100",
+      receiver: "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266",
+    },
+  },
+];
+
+async function main() {
+  const vectors = [];
+  for (const claim of claims) {
+    const depositor = new ethers.Wallet(depositorKeys[claim.depositor]);
+    vectors.push({
+      chainId: claim.message.chainId,
+      transactionHash: claim.message.transactionHash,
+      receiver: claim.message.receiver,
+      depositor: depositor.address,
+      digest: ethers.TypedDataEncoder.hash(claimDomain, claimTypes, claim.message),
+      signature: await depositor.signTypedData(claimDomain, claimTypes, claim.message),
+    });
+  }
+  writeFileSync(vectorsFile, JSON.stringify(vectors, null, 2) + "\n");
+  console.log(`Wrote ${vectors.length} claim vectors to ${vectorsFile}`);
+}
+
+// We recommend this pattern to be able to use async/await everywhere
+// and properly handle errors.
+main().catch((error) => {
+  console.error(error);
+  process.exitCode = 1;
+});
@@ -0,0 +1,23 @@
+import { ethers } from "hardhat";
+import { claimDomain, claimTypes, ClaimMessage } from "../utils/claim";
+
+const chainId = "test-chain";
+const transactionHash = "0x0000000000000000000000000000000000000000000000000000000000000000";
+const receiver = "0xaF7E68bCb2Fc7295492A00177f14F59B92814e70";
+
+async function main() {
+  const [depositor] = await ethers.getSigners();
+
+  const message: ClaimMessage = { chainId: chainId, transactionHash: transactionHash, receiver: receiver };
+  const proof = await depositor.signTypedData(claimDomain, claimTypes, message);
+
+  // The proof is the 65 byte signature, as bytes of the ClaimTokensTx
+  console.log({ depositor: depositor.address, proof: proof, ...message });
+}
+
+// We recommend this pattern to be able to use async/await everywhere
+// and properly handle errors.
+main().catch((error) => {
+  console.error(error);
+  process.exitCode = 1;
+});
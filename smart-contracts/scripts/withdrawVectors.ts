@@ -0,0 +1,67 @@
+import { ethers } from "hardhat";
+import { writeFileSync } from "fs";
+import { Signature } from "ethers";
+import { Ether } from "../utils/ethersUnits";
+import { erc20Name, erc20Ticker, maxSupply } from "../settings";
+
+// Test vectors of xnode-app/withdrawals_test.go, signed with the default hardhat accounts
+// Every voucher is redeemed on a fresh OpenWithdrawing deployment, so the vectors are exactly what its withdraw verifier accepts
+const vectorsFile = "../xnode-app/testdata/withdrawal_vectors.json";
+const ownerKey = "0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80";
+const withdrawals: { withdrawer: string; amount: bigint }[] = [
+  { withdrawer: "0x70997970C51812dc3A010C7d01b50e0d17dc79C8", amount: Ether(100) },
+  { withdrawer: "0x70997970C51812dc3A010C7d01b50e0d17dc79C8", amount: BigInt(1) }, // Nonce 1
+  { withdrawer: "0x3C44CdDdB6a900fa2b585dd299e03d12FA4293BC", amount: BigInt("123456789000000000000000") },
+];
+
+const withdrawTypes = {
+  Withdraw: [
+    { name: "withdrawer", type: "address" },
+    { name: "nonce", type: "uint256" },
+    { name: "amount", type: "uint256" },
+  ],
+};
+
+async function main() {
+  const owner = new ethers.Wallet(ownerKey, ethers.provider);
+  const OPEN = await ethers.deployContract("OPEN", [erc20Name, erc20Ticker, maxSupply, owner.address], owner);
+  const OpenWithdrawing = await ethers.deployContract("OpenWithdrawing", [await OPEN.getAddress(), owner.address], owner);
+  await OPEN.grantRole(ethers.keccak256(ethers.toUtf8Bytes("MINT")), await OpenWithdrawing.getAddress());
+
+  const domainInfo = await OpenWithdrawing.eip712Domain();
+  const domain = {
+    name: domainInfo.name,
+    version: domainInfo.version,
+    chainId: Number(domainInfo.chainId),
+    verifyingContract: domainInfo.verifyingContract,
+  };
+
+  const vectors = [];
+  for (const withdrawal of withdrawals) {
+    const message = { ...withdrawal, nonce: await OpenWithdrawing.getNonce(withdrawal.withdrawer) };
+    const signature = Signature.from(await owner.signTypedData(domain, withdrawTypes, message));
+    await (await OpenWithdrawing.withdraw(signature.v, signature.r, signature.s, message.withdrawer, message.amount)).wait();
+
+    vectors.push({
+      chainId: domain.chainId,
+      contract: domain.verifyingContract,
+      withdrawer: message.withdrawer,
+      nonce: Number(message.nonce),
+      amount: message.amount.toString(),
+      digest: ethers.TypedDataEncoder.hash(domain, withdrawTypes, message),
+      signer: owner.address,
+      v: signature.v,
+      r: signature.r,
+      s: signature.s,
+    });
+  }
+  writeFileSync(vectorsFile, JSON.stringify(vectors, null, 2) + "\n");
+  console.log(`Wrote ${vectors.length} withdrawal vectors to ${vectorsFile}`);
+}
+
+// We recommend this pattern to be able to use async/await everywhere
+// and properly handle errors.
+main().catch((error) => {
+  console.error(error);
+  process.exitCode = 1;
+});
@@ -0,0 +1,20 @@
+// EIP-712 claim of a deposit on the xnode chain, has to match xnode-app/claim.go
+
+export const claimDomain = {
+  name: "xnode-app",
+  version: "1",
+};
+
+export const claimTypes = {
+  Claim: [
+    { name: "chainId", type: "string" },
+    { name: "transactionHash", type: "bytes32" },
+    { name: "receiver", type: "string" },
+  ],
+};
+
+export interface ClaimMessage {
+  chainId: string; // CometBFT chain ID of the xnode chain
+  transactionHash: string; // Deposit (stake) transaction, 0x followed by 64 lower case hex characters
+  receiver: string; // CometBFT address or checksummed Ethereum address that receives the tokens
+}
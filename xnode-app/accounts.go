@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	eth "github.com/ethereum/go-ethereum/crypto"
+
+	"tendermint-app/txcodec"
+	"tendermint-app/u256"
+)
+
+// Accounts
+// Any address can hold tokens, validators and delegators use the same accounts for their unstaked tokens
+// An address is either the CometBFT address of an ed25519 key (40 upper case hex characters, as used by validators)
+// or the EIP-55 checksummed address of an Ethereum (secp256k1) key, so depositors can hold tokens under the address they deposited from
+// Every address has exactly one valid spelling, so an account can not be split over multiple entries
+// Token amounts are unsigned 256-bit integers with the 18 decimals of OPEN on Ethereum (see u256), transactions are checked
+// so that no balance can underflow or overflow, execution halts instead of wrapping around if that ever fails
+
+type Account struct {
+	Tokens u256.Int // Unstaked tokens, can be withdrawn, staked or delegated
+	Nonce  uint64   // To prevent replay attacks, signed as the SignDoc nonce
+
+	AccountNumber uint64 // Signed by every transaction, so signatures of a removed and recreated account can not be replayed
+
+	PubKey ed25519.PubKey // Known after the first signed transaction, Ethereum accounts recover it from their signatures instead
+
+	Rewards u256.Int // Staking rewards and commission, moved to Tokens with a withdraw rewards transaction
+}
+
+var cometAddressPattern = regexp.MustCompile("^[0-9A-F]{40}$")
+
+func isEthereumAddress(address string) bool {
+	return len(address) == 2*common.AddressLength+2 && address[:2] == "0x"
+}
+
+// Reports whether the address is the canonical spelling of a CometBFT or Ethereum address
+func validAccountAddress(address string) bool {
+	if isEthereumAddress(address) {
+		return common.IsHexAddress(address) && common.HexToAddress(address).Hex() == address
+	}
+	return cometAddressPattern.MatchString(address)
+}
+
+// Checks the signature of a transaction over its sign bytes
+// Ethereum accounts sign the sign bytes as an EIP-191 personal message (65 byte signature), other accounts sign with their ed25519 key
+func verifySignature(tx txcodec.SignedTx, signer Account, signBytes []byte) error {
+	auth := tx.Authentication()
+	if isEthereumAddress(tx.Signer()) {
+		if len(auth.Signature) != 65 {
+			return errors.New("signature should be 65 bytes")
+		}
+		signature := append([]byte{}, auth.Signature...)
+		if signature[64] >= 27 {
+			signature[64] -= 27 // Wallets use 27 and 28 as recovery id
+		}
+		pubKey, err := eth.SigToPub(accounts.TextHash(signBytes), signature)
+		if err != nil || eth.PubkeyToAddress(*pubKey).Hex() != tx.Signer() {
+			return errors.New("signature is not valid")
+		}
+		return nil
+	}
+
+	pubKey := signer.PubKey
+	if createValidatorTx, creating := tx.(*txcodec.CreateValidatorTx); creating {
+		pubKey = createValidatorTx.PubKey // Signed by the new consensus key, proving the account owns it
+	} else if len(pubKey) == 0 {
+		pubKey = auth.PubKey // Accounts created by a claim have no public key yet
+		if len(pubKey) != ed25519.PubKeySize || pubKey.Address().String() != tx.Signer() {
+			return errors.New("public key does not belong to signer")
+		}
+	}
+	if len(pubKey) != ed25519.PubKeySize || !pubKey.VerifySignature(signBytes, auth.Signature) {
+		return errors.New("signature is not valid")
+	}
+	return nil
+}
@@ -2,18 +2,20 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math/big"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
+	dbm "github.com/cometbft/cometbft-db"
 	"github.com/cometbft/cometbft/abci/types"
 	cfg "github.com/cometbft/cometbft/config"
-	"github.com/cometbft/cometbft/crypto"
 	"github.com/cometbft/cometbft/crypto/ed25519"
 	"github.com/cometbft/cometbft/libs/bytes"
 	cmtflags "github.com/cometbft/cometbft/libs/cli/flags"
@@ -23,39 +25,66 @@ import (
 	"github.com/cometbft/cometbft/p2p"
 	"github.com/cometbft/cometbft/privval"
 	"github.com/cometbft/cometbft/proxy"
+	"github.com/cometbft/cometbft/state/txindex"
 	"github.com/spf13/viper"
 
 	"net/http"
 
+	"github.com/ethereum/go-ethereum/common"
 	eth "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/gorilla/websocket"
+
+	"tendermint-app/txcodec"
+	"tendermint-app/u256"
 )
 
 const (
 	CodeTypeOK                           uint32 = 0
 	CodeTypeTransactionTypeDecodingError uint32 = 1
 	CodeTypeTransactionDecodingError     uint32 = 2
+	CodeTypeProposerOnlyTransaction      uint32 = 3
 
 	CodeTypeDataNotVerified uint32 = 10
 	CodeTypeDataOutdated    uint32 = 11
 	CodeTypeDataTooNew      uint32 = 12
+	CodeTypeDataAttestation uint32 = 13
+	CodeTypeInvalidDataFeed uint32 = 14
 
 	CodeTypeNotEnoughStakedTokens   uint32 = 20
 	CodeTypeNotEnoughUnstakedTokens uint32 = 21
 	CodeTypeInvalidSingature        uint32 = 22
+	CodeTypeInvalidFee              uint32 = 23
+	CodeTypeValidatorExists         uint32 = 24
+	CodeTypeInvalidValidator        uint32 = 25
+	CodeTypeValidatorJailed         uint32 = 26
+	CodeTypeNoRewards               uint32 = 27
+	CodeTypeInvalidAddress          uint32 = 28
+	CodeTypeAmountOverflow          uint32 = 29
 
 	CodeTypeDepositNotVerified      uint32 = 30
 	CodeTypeDepositInvalidSignature uint32 = 31
 
+	CodeTypeUnknownQueryPath uint32 = 40
+	CodeTypeInvalidQuery     uint32 = 41
+	CodeTypeNotFound         uint32 = 42
+
 	CodeTypeUnknownError uint32 = 999
 )
 
+// Created at genesis or with a create validator transaction, the unstaked tokens of a validator are in the account with the same address
 type AbciValidator struct {
-	PubKey          crypto.PubKey
-	GovernancePower int64 // Staked tokens, can be unstaked
-	// Tokens has 9 decimals (so * 10^9 to convert to blockchain tokens, / 10*9 to convert to blockchain coins)
-	Tokens int64  // Unstaked tokens, can be withdrawn or staked
-	Nonce  uint32 // To prevent replay attacks
+	PubKey          ed25519.PubKey // Consensus key, concrete key type so the validator can be stored as JSON
+	GovernancePower u256.Int       // Staked tokens, can be unstaked
+
+	Moniker        string
+	CommissionRate uint32 // Basis points
+
+	DelegatorShares u256.Int // Total shares of all delegations to this validator, GovernancePower is split between them
+
+	Jailed      bool  // Removed from the validator set because of misbehavior, until it unjails
+	JailedUntil int64 // Unix timestamp from which an unjail transaction is accepted
+	Tombstoned  bool  // Double signed, can never unjail
 }
 
 type VerifiedDataItem struct {
@@ -66,61 +95,211 @@ type VerifiedDataItem struct {
 type Application struct {
 	types.BaseApplication
 
-	Validators   map[string]AbciValidator    // Address -> Validator info
-	VerifiedData map[string]VerifiedDataItem // Datafeed -> Data item
+	db dbm.DB
+
+	ChainID      string
+	Accounts     map[string]Account           // Address -> Account
+	Validators   map[string]AbciValidator     // Address -> Validator info
+	VerifiedData map[string]VerifiedDataItem  // Datafeed -> Data item
+	AttestedData map[string]map[uint64]string // Datafeed -> timestamp -> data, observed by more than 2/3 of the voting power
+
+	Deposits    map[string]DepositRecord // Transaction hash -> deposit observed by more than 2/3 of the voting power, kept after the claim so it can never be claimed again
+	Withdrawals map[string][]Withdrawal  // Ethereum address -> withdrawals to it, in OpenWithdrawing nonce order
+
+	Delegations map[string]map[string]u256.Int // Validator address -> delegator address -> shares (including the self delegation)
+	Unbondings  map[string][]UnbondingEntry    // Validator address -> tokens leaving the validator, in order of unbonding
+
+	SigningInfos map[string]SigningInfo // Validator address -> liveness of the validator in the recent blocks
+	ActiveSet    map[string]int64       // Validator address -> consensus power, as last sent to CometBFT
+
+	Params Params // Set at genesis
+
+	CommunityPool u256.Int  // Share of the minted rewards that is not owned by any account
+	InflationTime time.Time // Block time up to which rewards were minted
 
 	TotalTransactions uint32
+	NextAccountNumber uint64
+
+	LastBlockHeight  int64  // Height of the last committed block
+	LastBlockAppHash []byte // App hash of the last committed block
+
+	finalizedHeight  int64                      // Height of the block that has been finalized, but not yet committed
+	finalizedAppHash []byte                     // App hash of the block that has been finalized, but not yet committed
+	finalized        map[string][]byte          // State entries after the finalized block
+	committed        map[string][]byte          // State entries as they are stored in the database
+	dirty            map[string]bool            // Keys of the entries that changed since the state was last finalized
+	unsaved          map[string]bool            // Keys of the finalized entries that changed since the last commit
+	tree             *stateTree                 // Merkle tree over the finalized entries
+	depositors       map[string]map[string]bool // Ethereum address -> transaction hashes of its committed deposits, so deposit queries do not scan every deposit
+
+	SnapshotInterval   uint64            // Create a state sync snapshot every this many blocks (0 to disable)
+	SnapshotKeepRecent uint32            // Amount of snapshots to keep
+	FeedHistoryDepth   uint64            // Blocks of data feed history to keep (0 to keep everything)
+	LegacyJSONTxs      bool              // Accept transactions in the legacy JSON encoding, has to be the same for all validators
+	TxIndexer          txindex.TxIndexer // Transactions indexed by CometBFT, searched by the transfers query (nil if not available)
+	WithdrawalSigner   *withdrawalSigner // Signs withdrawal vouchers for queries (nil if this node does not have the key)
+	restore            *snapshotRestore
+
+	xnode *xnodeObservations
+	now   func() time.Time // Clock for mempool admission, block execution uses the block time instead
 }
 
-// Transactions
-const (
-	TransactionValidateData uint8 = 0
+// Block that is being executed, or simulated to check a proposal
+// Execution can only depend on the state and this context, so every node gets the same result
+type blockContext struct {
+	Height          int64
+	Time            time.Time // Block time as decided by consensus
+	ProposerAddress string    // Receives the transaction fees
+	LastCommit      *types.CommitInfo
+	Attested        bool // Block already contains attestations
+}
 
-	TransactionStakeTokens    uint8 = 10
-	TransactionClaimTokens    uint8 = 11
-	TransactionWithdrawTokens uint8 = 12
-)
+func newBlockContext(height int64, blockTime time.Time, proposerAddress []byte, lastCommit *types.CommitInfo) *blockContext {
+	return &blockContext{Height: height, Time: blockTime, ProposerAddress: bytes.HexBytes(proposerAddress).String(), LastCommit: lastCommit}
+}
 
-type Transaction struct {
-	TransactionType uint8
+// Transactions are encoded with txcodec, the legacy JSON encoding is only accepted if LegacyJSONTxs is set
+func (app *Application) decodeTx(transaction []byte) (txcodec.Tx, error) {
+	if app.LegacyJSONTxs && txcodec.IsLegacyJSON(transaction) {
+		return txcodec.DecodeLegacyJSON(transaction)
+	}
+	return txcodec.Decode(transaction)
 }
 
-// Try to reach consensus about a piece of data
-type ValidateDataTx struct {
-	DataFeed      string
-	DataValue     string
-	DataTimestamp uint64
+// Xnode
+// Observations of the xnode connected to this node, these differ per node so they are only used for mempool admission and vote extensions
+// Deposits count as observed once the Ethereum head the xnode reported is Params.DepositConfirmations blocks deep past them,
+// deposits the xnode reports as reorged out are forgotten and retracted on chain if they were attested but not yet claimed
+type xnodeObservations struct {
+	mutex    sync.RWMutex
+	data     map[string]map[uint64]string // datafeed -> timestamp -> data
+	deposits map[string]DepositItem       // transaction hash -> deposit info
+	removed  map[string]string            // transaction hash -> hash of the block the deposit was reorged out of
+	head     uint64                       // Latest Ethereum block number
 }
 
-// Stake / Unstake tokens
-type StakeTokensTx struct {
-	Amount           int64 // Negative amount to unstake
-	ValidatorAddress string
-	Proof            string // "Stake" + Amount (hex) + Nonce (hex)
+func newXnodeObservations() *xnodeObservations {
+	return &xnodeObservations{data: make(map[string]map[uint64]string), deposits: make(map[string]DepositItem), removed: make(map[string]string)}
 }
 
-// Claim tokens by providing ethereum transaction hash, proof is from the ethereum address that deposited their tokens
-type ClaimTokensTx struct {
-	TransactionHash  string
-	ValidatorAddress string
-	Proof            string
+func (xnode *xnodeObservations) addData(dataFeed string, timestamp uint64, value string) {
+	xnode.mutex.Lock()
+	defer xnode.mutex.Unlock()
+	if _, exists := xnode.data[dataFeed]; !exists {
+		xnode.data[dataFeed] = make(map[uint64]string)
+	}
+	xnode.data[dataFeed][timestamp] = value
 }
 
-// Withdraw unstaked tokens to ethreum blockchain
-type WithdrawTokensTx struct {
-	Amount           int64
-	Address          string
-	ValidatorAddress string
-	Proof            string // "Withdraw" + Amount (hex) + Address + None (hex)
+func (xnode *xnodeObservations) getData(dataFeed string, timestamp uint64) (string, bool) {
+	xnode.mutex.RLock()
+	defer xnode.mutex.RUnlock()
+	value, exists := xnode.data[dataFeed][timestamp]
+	return value, exists
 }
 
-// Xnode
-var verifiedXnodeData = make(map[string]map[uint64]string) // datafeed -> timestamp -> data
-var verifiedDeposits = make(map[string]DepositItem)        // transaction hash -> deposit info
+// Forgets observations that can never become verified anymore and observations that are attested, those are verified from the state
+func (xnode *xnodeObservations) pruneData(verified map[string]VerifiedDataItem, attested map[string]map[uint64]string) {
+	xnode.mutex.Lock()
+	defer xnode.mutex.Unlock()
+	for dataFeed, observations := range xnode.data {
+		for timestamp, value := range observations {
+			if attestedValue, exists := attested[dataFeed][timestamp]; timestamp <= verified[dataFeed].Timestamp || (exists && attestedValue == value) {
+				delete(observations, timestamp)
+			}
+		}
+		if len(observations) == 0 {
+			delete(xnode.data, dataFeed)
+		}
+	}
+}
+
+// Copy of all observed data, safe to use while the xnode keeps sending data
+func (xnode *xnodeObservations) allData() map[string]map[uint64]string {
+	xnode.mutex.RLock()
+	defer xnode.mutex.RUnlock()
+	data := make(map[string]map[uint64]string, len(xnode.data))
+	for dataFeed, observations := range xnode.data {
+		data[dataFeed] = make(map[uint64]string, len(observations))
+		for timestamp, value := range observations {
+			data[dataFeed][timestamp] = value
+		}
+	}
+	return data
+}
+
+func (xnode *xnodeObservations) addDeposit(transactionHash string, deposit DepositItem) {
+	xnode.mutex.Lock()
+	defer xnode.mutex.Unlock()
+	xnode.deposits[transactionHash] = deposit
+}
+
+func (xnode *xnodeObservations) getDeposit(transactionHash string) (DepositItem, bool) {
+	xnode.mutex.RLock()
+	defer xnode.mutex.RUnlock()
+	deposit, exists := xnode.deposits[transactionHash]
+	return deposit, exists
+}
+
+// Copy of the observed deposits with at least the given confirmations, safe to use while the xnode keeps sending deposits
+func (xnode *xnodeObservations) confirmedDeposits(confirmations int64) map[string]DepositItem {
+	xnode.mutex.RLock()
+	defer xnode.mutex.RUnlock()
+	deposits := make(map[string]DepositItem, len(xnode.deposits))
+	for transactionHash, deposit := range xnode.deposits {
+		if xnode.confirmations(deposit) >= uint64(confirmations) {
+			deposits[transactionHash] = deposit
+		}
+	}
+	return deposits
+}
+
+// Blocks on top of the deposit, including its own block (caller holds the mutex)
+func (xnode *xnodeObservations) confirmations(deposit DepositItem) uint64 {
+	if xnode.head < deposit.BlockNumber {
+		return 0
+	}
+	return xnode.head - deposit.BlockNumber + 1
+}
+
+func (xnode *xnodeObservations) getConfirmations(deposit DepositItem) uint64 {
+	xnode.mutex.RLock()
+	defer xnode.mutex.RUnlock()
+	return xnode.confirmations(deposit)
+}
+
+func (xnode *xnodeObservations) setHead(blockNumber uint64) {
+	xnode.mutex.Lock()
+	defer xnode.mutex.Unlock()
+	xnode.head = max(xnode.head, blockNumber) // A reorg to a shorter chain still leaves the deposits in it confirmed
+}
+
+// Forgets the deposit if it was observed in the reorged block, a deposit that was mined again in another block is kept
+func (xnode *xnodeObservations) removeDeposit(transactionHash string, blockHash string) {
+	xnode.mutex.Lock()
+	defer xnode.mutex.Unlock()
+	if xnode.deposits[transactionHash].BlockHash == blockHash {
+		delete(xnode.deposits, transactionHash)
+	}
+	xnode.removed[transactionHash] = blockHash
+}
+
+// Copy of the deposits that were reorged out, transaction hash -> block hash
+func (xnode *xnodeObservations) removedDeposits() map[string]string {
+	xnode.mutex.RLock()
+	defer xnode.mutex.RUnlock()
+	removed := make(map[string]string, len(xnode.removed))
+	for transactionHash, blockHash := range xnode.removed {
+		removed[transactionHash] = blockHash
+	}
+	return removed
+}
 
 const (
-	XnodeMessageData    uint8 = 0
-	XnodeMessageDeposit uint8 = 1
+	XnodeMessageData          uint8 = 0
+	XnodeMessageDeposit       uint8 = 1
+	XnodeMessageHead          uint8 = 2 // Latest Ethereum block, deposits are confirmed relative to it
+	XnodeMessageRemoveDeposit uint8 = 3 // Deposit log was removed from the canonical chain by a reorg
 )
 
 type XnodeMessage struct {
@@ -134,8 +313,21 @@ type XnodeDataMessage struct {
 }
 
 type DepositItem struct {
-	Address string
-	Amount  int64
+	Address string   // Checksummed Ethereum address of the depositor
+	Amount  u256.Int // Amount of the ERC20 transfer, tokens have the same 18 decimals on both chains
+
+	BlockNumber uint64 // Ethereum block that contains the deposit
+	BlockHash   string // 0x followed by 64 lower case hex characters
+	LogIndex    uint64 // Position of the deposit log in the block
+}
+
+// Attested deposit, claimed by at most one ClaimTokensTx
+type DepositRecord struct {
+	DepositItem
+	AttestedHeight int64 // Height of the last attestation, the deposit can be attested again (with other info) until it is claimed
+	Claimed        bool
+	ClaimedHeight  int64
+	Receiver       string // Account that received the claimed tokens
 }
 
 type XnodeDepositMessage struct {
@@ -143,7 +335,16 @@ type XnodeDepositMessage struct {
 	DepositInfo     DepositItem
 }
 
-func receiveXnodeData(w http.ResponseWriter, r *http.Request) {
+type XnodeHeadMessage struct {
+	BlockNumber uint64
+}
+
+type XnodeRemoveDepositMessage struct {
+	TransactionHash string
+	BlockHash       string // Block the deposit was observed in
+}
+
+func (app *Application) receiveXnodeData(w http.ResponseWriter, r *http.Request) {
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Fatal("Xnode upgrade error", "err", err)
@@ -171,12 +372,11 @@ func receiveXnodeData(w http.ResponseWriter, r *http.Request) {
 				log.Fatal("Xnode data message decode error", "err", err)
 			}
 
-			_, mapExists := verifiedXnodeData[xnodeData.DataFeed]
-			if !mapExists {
-				verifiedXnodeData[xnodeData.DataFeed] = make(map[uint64]string)
+			if !validDataFeed(xnodeData.DataFeed) {
+				log.Printf("Invalid data feed %q ignored", xnodeData.DataFeed)
+				continue
 			}
-
-			verifiedXnodeData[xnodeData.DataFeed][xnodeData.DataTimestamp] = xnodeData.DataValue
+			app.xnode.addData(xnodeData.DataFeed, xnodeData.DataTimestamp, xnodeData.DataValue)
 			log.Printf("Verified %v added: %v at %d", xnodeData.DataFeed, xnodeData.DataValue, xnodeData.DataTimestamp)
 		case XnodeMessageDeposit:
 			xnodeDeposit := &XnodeDepositMessage{}
@@ -185,8 +385,34 @@ func receiveXnodeData(w http.ResponseWriter, r *http.Request) {
 				log.Fatal("Xnode deposit message decode error", "err", err)
 			}
 
-			verifiedDeposits[xnodeDeposit.TransactionHash] = xnodeDeposit.DepositInfo
-			log.Printf("Verified deposit %v added: (%d from %v)", xnodeDeposit.TransactionHash, xnodeDeposit.DepositInfo.Amount, xnodeDeposit.DepositInfo.Address)
+			// Only one spelling of a deposit can be attested, as the validators have to observe exactly the same deposit
+			transactionHash := strings.ToLower(xnodeDeposit.TransactionHash)
+			deposit := xnodeDeposit.DepositInfo
+			deposit.BlockHash = strings.ToLower(deposit.BlockHash)
+			if !validTransactionHash(transactionHash) || !common.IsHexAddress(deposit.Address) || deposit.Amount.IsZero() || deposit.BlockNumber == 0 || !validTransactionHash(deposit.BlockHash) {
+				log.Printf("Invalid deposit %v ignored: (%v from %v in block %d %v)", xnodeDeposit.TransactionHash, deposit.Amount, deposit.Address, deposit.BlockNumber, deposit.BlockHash)
+				continue
+			}
+			deposit.Address = common.HexToAddress(deposit.Address).Hex()
+			app.xnode.addDeposit(transactionHash, deposit)
+			log.Printf("Verified deposit %v added: (%v from %v in block %d)", transactionHash, deposit.Amount, deposit.Address, deposit.BlockNumber)
+		case XnodeMessageHead:
+			xnodeHead := &XnodeHeadMessage{}
+			err = json.Unmarshal(message, xnodeHead)
+			if err != nil {
+				log.Fatal("Xnode head message decode error", "err", err)
+			}
+
+			app.xnode.setHead(xnodeHead.BlockNumber)
+		case XnodeMessageRemoveDeposit:
+			xnodeRemove := &XnodeRemoveDepositMessage{}
+			err = json.Unmarshal(message, xnodeRemove)
+			if err != nil {
+				log.Fatal("Xnode remove deposit message decode error", "err", err)
+			}
+
+			app.xnode.removeDeposit(strings.ToLower(xnodeRemove.TransactionHash), strings.ToLower(xnodeRemove.BlockHash))
+			log.Printf("Deposit %v removed by a reorg of block %v", xnodeRemove.TransactionHash, xnodeRemove.BlockHash)
 		}
 
 	}
@@ -195,9 +421,29 @@ func receiveXnodeData(w http.ResponseWriter, r *http.Request) {
 var upgrader = websocket.Upgrader{} // use default options
 var addr = flag.String("addr", "0.0.0.0:8088", "Address for websocket receiving xnode data")
 var homeDir = flag.String("cmt-home", "", "Path to the CometBFT config directory (if empty, uses $HOME/.cometbft)")
+var snapshotInterval = flag.Uint64("snapshot-interval", 1000, "Create a state sync snapshot every this many blocks (0 to disable)")
+var snapshotKeepRecent = flag.Uint("snapshot-keep-recent", 2, "Amount of state sync snapshots to keep")
+var legacyJSONTxs = flag.Bool("legacy-json-txs", false, "Accept transactions in the legacy JSON encoding during the migration to txcodec (all validators have to use the same setting)")
+var feedHistoryDepth = flag.Uint64("feed-history-depth", 0, "Blocks of data feed history to keep for queries (0 to keep everything)")
+var withdrawSignerKey = flag.String("withdraw-signer-key", "", "File with the hex private key of the OpenWithdrawing owner, to sign withdrawal vouchers (if empty, vouchers can not be queried from this node)")
+var withdrawChainID = flag.Uint64("withdraw-chain-id", 11155111, "Ethereum chain ID of the OpenWithdrawing contract")
+var withdrawContract = flag.String("withdraw-contract", "0x734eBF68D9634086157c8E655f177Ad9C99DAD7B", "Address of the OpenWithdrawing contract")
+var depositRPC = flag.String("deposit-rpc", "", "Ethereum JSON-RPC endpoint to watch the deposit contracts on (if empty, deposits are only received from the xnode)")
+var depositStakingContract = flag.String("deposit-staking-contract", "0xB88E40E8289665EE3e7493753d79D5C0606F384C", "Address of the OpenStaking contract, every TokensStaked event is a deposit")
+var depositValidatorPassContract = flag.String("deposit-validatorpass-contract", "0xeeFbA1882Bc5d0775002b0618C051a0B052EE6dE", "Address of the ValidatorPass contract, every mint is a deposit of 10,000 tokens")
+var depositStartBlock = flag.Uint64("deposit-start-block", 0, "First Ethereum block to watch for deposits (if 0, starts at the latest blocks)")
+var depositPollInterval = flag.Duration("deposit-poll-interval", 12*time.Second, "Time between polls of the Ethereum JSON-RPC endpoint for new deposits")
+
+var (
+	oneToken              = u256.New(1_000_000_000_000_000_000)       // Tokens have 18 decimals
+	minimumValidatorPower = u256.Must(u256.New(10_000).Mul(oneToken)) // 10,000 tokens
+)
 
 const (
-	minimumValidatorPower = 10_000*10 ^ 9
+	maxMonikerLength  = 70
+	maxCommissionRate = basisPoints // 100%
+
+	basisPoints = 10_000
 )
 
 func main() {
@@ -219,20 +465,47 @@ func main() {
 	if err := config.ValidateBasic(); err != nil {
 		log.Fatalf("Invalid configuration data: %v", err)
 	}
-	// dbPath := filepath.Join(*homeDir, "badger")
-	// db, err := badger.Open(badger.DefaultOptions(dbPath))
+	// Application state is stored next to the CometBFT databases, using the same backend
+	db, err := dbm.NewDB("xnode-app", dbm.BackendType(config.DBBackend), config.DBDir())
+	if err != nil {
+		log.Fatalf("Opening database: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Closing database: %v", err)
+		}
+	}()
 
-	// if err != nil {
-	//     log.Fatalf("Opening database: %v", err)
-	// }
-	// defer func() {
-	//     if err := db.Close(); err != nil {
-	//         log.Printf("Closing database: %v", err)
-	//     }
-	// }()
+	app, err := NewApplication(db)
+	if err != nil {
+		log.Fatalf("Loading application state: %v", err)
+	}
+	app.SnapshotInterval = *snapshotInterval
+	app.SnapshotKeepRecent = uint32(*snapshotKeepRecent)
+	app.FeedHistoryDepth = *feedHistoryDepth
+	app.LegacyJSONTxs = *legacyJSONTxs
+	if *withdrawSignerKey != "" {
+		key, err := eth.LoadECDSA(*withdrawSignerKey)
+		if err != nil {
+			log.Fatalf("Loading withdrawal signer key: %v", err)
+		}
+		if !common.IsHexAddress(*withdrawContract) {
+			log.Fatalf("Invalid withdraw contract address %v", *withdrawContract)
+		}
+		app.WithdrawalSigner = &withdrawalSigner{key: key, chainID: new(big.Int).SetUint64(*withdrawChainID), contract: common.HexToAddress(*withdrawContract)}
+	}
 
-	// app := NewApplication(db)
-	app := NewApplication()
+	if *depositRPC != "" {
+		if !common.IsHexAddress(*depositStakingContract) || !common.IsHexAddress(*depositValidatorPassContract) {
+			log.Fatalf("Invalid deposit contract address %v or %v", *depositStakingContract, *depositValidatorPassContract)
+		}
+		client, err := ethclient.Dial(*depositRPC)
+		if err != nil {
+			log.Fatalf("Connecting to the Ethereum RPC: %v", err)
+		}
+		watcher := newDepositWatcher(client, app.xnode, common.HexToAddress(*depositStakingContract), common.HexToAddress(*depositValidatorPassContract), *depositStartBlock)
+		go watcher.run(context.Background(), *depositPollInterval)
+	}
 
 	pv := privval.LoadFilePV(
 		config.PrivValidatorKeyFile(),
@@ -267,6 +540,16 @@ func main() {
 		log.Fatalf("Creating node: %v", err)
 	}
 
+	// Same indexer as the RPC, it indexes the transaction events after every block
+	// Set before the node starts, so queries never race with it
+	rpcEnv, err := node.ConfigureRPC()
+	if err != nil {
+		log.Fatalf("Configuring transaction search: %v", err)
+	}
+	if config.TxIndex.Indexer != "null" {
+		app.TxIndexer = rpcEnv.TxIndexer
+	}
+
 	if err := node.Start(); err != nil {
 		log.Fatalf("failed to start node: %v", err)
 	}
@@ -282,15 +565,44 @@ func main() {
 	})
 
 	// Xnode communication
-	http.HandleFunc("/", receiveXnodeData)
+	http.HandleFunc("/", app.receiveXnodeData)
 	log.Fatalf("xnode listener error: %v", http.ListenAndServe(*addr, nil))
 
 	// Run forever.
 	select {}
 }
 
-func NewApplication() *Application {
-	return &Application{Validators: make(map[string]AbciValidator), VerifiedData: make(map[string]VerifiedDataItem)}
+func NewApplication(db dbm.DB) (*Application, error) {
+	app := &Application{
+		db:           db,
+		Accounts:     make(map[string]Account),
+		Validators:   make(map[string]AbciValidator),
+		VerifiedData: make(map[string]VerifiedDataItem),
+		AttestedData: make(map[string]map[uint64]string),
+
+		Deposits:    make(map[string]DepositRecord),
+		Withdrawals: make(map[string][]Withdrawal),
+
+		Delegations: make(map[string]map[string]u256.Int),
+		Unbondings:  make(map[string][]UnbondingEntry),
+
+		SigningInfos: make(map[string]SigningInfo),
+		ActiveSet:    make(map[string]int64),
+
+		finalized:  make(map[string][]byte),
+		committed:  make(map[string][]byte),
+		dirty:      make(map[string]bool),
+		unsaved:    make(map[string]bool),
+		tree:       newStateTree(nil),
+		depositors: make(map[string]map[string]bool),
+
+		xnode: newXnodeObservations(),
+		now:   time.Now,
+	}
+	if err := app.loadState(); err != nil {
+		return nil, err
+	}
+	return app, nil
 }
 
 func (app *Application) Info(_ context.Context, info *types.RequestInfo) (*types.ResponseInfo, error) {
@@ -308,373 +620,670 @@ func (app *Application) Info(_ context.Context, info *types.RequestInfo) (*types
 		}, err
 	}
 
-	return &types.ResponseInfo{Data: fmt.Sprintf("{\"VerifiedData\":%v,\"Validators\":%v,\"TotalUpdates\":%v}", string(verifiedData), string(validators), app.TotalTransactions)}, nil
+	return &types.ResponseInfo{
+		Data:             fmt.Sprintf("{\"VerifiedData\":%v,\"Validators\":%v,\"TotalUpdates\":%v}", string(verifiedData), string(validators), app.TotalTransactions),
+		LastBlockHeight:  app.LastBlockHeight,
+		LastBlockAppHash: app.LastBlockAppHash,
+	}, nil
 }
 
-func (app *Application) Query(_ context.Context, req *types.RequestQuery) (*types.ResponseQuery, error) {
-	switch req.Path {
-	case "tx":
-		return &types.ResponseQuery{Value: []byte(fmt.Sprintf("%v", app.TotalTransactions))}, nil
-	default:
-		return &types.ResponseQuery{Log: fmt.Sprintf("Invalid query path. Expected price, time or tx, got %v", req.Path)}, nil
+func (app *Application) CheckTx(_ context.Context, check *types.RequestCheckTx) (*types.ResponseCheckTx, error) {
+	tx, err := app.decodeTx(check.Tx)
+	if err != nil {
+		return decodeError(err), err
 	}
+	return app.checkTx(tx, nil)
 }
 
-func (app *Application) CheckTx(_ context.Context, check *types.RequestCheckTx) (*types.ResponseCheckTx, error) {
-	tx := &Transaction{}
-	err := json.Unmarshal(check.Tx, tx)
-	if err != nil {
+func decodeError(err error) *types.ResponseCheckTx {
+	if errors.Is(err, txcodec.ErrUnknownType) {
 		return &types.ResponseCheckTx{
 			Code: CodeTypeTransactionTypeDecodingError,
 			Log:  fmt.Sprint("Not able to parse transaction type", "err", err),
-		}, err
+		}
+	}
+	return &types.ResponseCheckTx{
+		Code: CodeTypeTransactionDecodingError,
+		Log:  fmt.Sprint("Not able to parse transaction", "err", err),
 	}
+}
 
-	switch tx.TransactionType {
-	case TransactionValidateData:
-		validateDataTx := &ValidateDataTx{}
-		err := json.Unmarshal(check.Tx, validateDataTx)
-		if err != nil {
+// Checks a transaction against the current state
+// In the mempool (block is nil) data and deposits have to be confirmed by our xnode, during execution they have to be attested
+func (app *Application) checkTx(tx txcodec.Tx, block *blockContext) (*types.ResponseCheckTx, error) {
+	execution := block != nil
+	now := app.now()
+	if execution {
+		now = block.Time
+	}
+
+	// The fee is paid to the proposer, which is only known during execution
+	if signedTx, signed := tx.(txcodec.SignedTx); signed && execution {
+		fee := signedTx.Authentication().Fee
+		if _, err := app.Accounts[block.ProposerAddress].Tokens.Add(fee); err != nil {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeTransactionDecodingError,
-				Log:  fmt.Sprint("Not able to parse validate data transaction", "err", err),
+				Code: CodeTypeAmountOverflow,
+				Log:  fmt.Sprintf("Proposer %v can not hold %v more tokens", block.ProposerAddress, fee),
 			}, err
 		}
+	}
 
-		latestAllowedTimestamp := uint64(time.Now().Unix()) - 1 // Validators should have at least 1 second to receive the data
-		if validateDataTx.DataTimestamp >= latestAllowedTimestamp {
+	switch tx := tx.(type) {
+	case *txcodec.ValidateDataTx:
+		if !validDataFeed(tx.DataFeed) {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeInvalidDataFeed,
+				Log:  fmt.Sprintf("Data feed should be non empty without control characters (attempted: %q)", tx.DataFeed),
+			}, errors.New("invalid data feed")
+		}
+
+		latestAllowedTimestamp := uint64(now.Unix()) - 1 // Validators should have at least 1 second to receive the data
+		if tx.DataTimestamp >= latestAllowedTimestamp {
 			// Is this exploitable? Evil validators accepting transcations that are just under 1 second
 			// low latency validators not accepting, higher latency validators do accept (low latency validators get punished?)
 
 			return &types.ResponseCheckTx{
 				Code: CodeTypeDataTooNew,
 				Log: fmt.Sprintf("New transaction timestamp is not old enough (attempted: %d, latest accepted: %d)",
-					validateDataTx.DataTimestamp,
+					tx.DataTimestamp,
 					latestAllowedTimestamp,
 				),
 			}, errors.New("new transaction timestamp is not old enough")
 		}
 
-		if validateDataTx.DataTimestamp <= app.VerifiedData[validateDataTx.DataFeed].Timestamp {
+		if tx.DataTimestamp <= app.VerifiedData[tx.DataFeed].Timestamp {
 			return &types.ResponseCheckTx{
 				Code: CodeTypeDataOutdated,
 				Log: fmt.Sprintf("New transaction timestamp is not newer than latest one (attempted: %d, latest: %d)",
-					validateDataTx.DataTimestamp,
-					app.VerifiedData[validateDataTx.DataFeed].Timestamp,
+					tx.DataTimestamp,
+					app.VerifiedData[tx.DataFeed].Timestamp,
 				),
 			}, errors.New("new transaction timestamp is not newer than latest one")
 		}
-		dataFromXnode, exists := verifiedXnodeData[validateDataTx.DataFeed][validateDataTx.DataTimestamp]
-		if !exists || dataFromXnode != validateDataTx.DataValue {
+		if attestedData, exists := app.AttestedData[tx.DataFeed][tx.DataTimestamp]; exists && attestedData == tx.DataValue {
+			break // Our xnode forgets its observations once they are attested
+		}
+		if execution {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeDataNotVerified,
+				Log: fmt.Sprintf("New transaction data is not attested by the validators (attempted: %v at %d)",
+					tx.DataValue,
+					tx.DataTimestamp,
+				),
+			}, errors.New("new transaction data is not attested by the validators")
+		}
+		dataFromXnode, exists := app.xnode.getData(tx.DataFeed, tx.DataTimestamp)
+		if !exists || dataFromXnode != tx.DataValue {
 			return &types.ResponseCheckTx{
 				Code: CodeTypeDataNotVerified,
 				Log: fmt.Sprintf("New transaction data is not confirmed by our xnode (attempted: %v at %d)",
-					validateDataTx.DataValue,
-					validateDataTx.DataTimestamp,
+					tx.DataValue,
+					tx.DataTimestamp,
 				),
 			}, errors.New("new transaction data is not confirmed by our xnode")
 		}
 
-	case TransactionStakeTokens:
-		stakeTokensTx := &StakeTokensTx{}
-		err := json.Unmarshal(check.Tx, stakeTokensTx)
-		if err != nil {
+	case *txcodec.AttestDataTx:
+		if !execution {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeTransactionDecodingError,
-				Log:  fmt.Sprint("Not able to parse stake tokens transaction", "err", err),
-			}, err
+				Code: CodeTypeProposerOnlyTransaction,
+				Log:  "Attest data transactions can only be added by the block proposer",
+			}, errors.New("attest data transactions can only be added by the block proposer")
+		}
+
+	case *txcodec.StakeTokensTx:
+		if check, err := app.verifySignedTx(tx); check != nil {
+			return check, err
 		}
 
-		validator := app.Validators[stakeTokensTx.ValidatorAddress]
-		if stakeTokensTx.Amount > 0 && validator.Tokens-stakeTokensTx.Amount >= 0 {
+		validator, exists := app.Validators[tx.ValidatorAddress]
+		if !exists {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeInvalidValidator,
+				Log:  fmt.Sprintf("Validator %v does not exist, create it before staking", tx.ValidatorAddress),
+			}, errors.New("validator does not exist")
+		}
+		account := app.Accounts[tx.ValidatorAddress]
+		if !tx.Unstake && tx.Amount.Gt(spendableTokens(tx, account)) {
 			return &types.ResponseCheckTx{
 				Code: CodeTypeNotEnoughUnstakedTokens,
-				Log:  fmt.Sprintf("Trying to stake more tokens than unstaked (attempted: %d, unstaked: %d)", stakeTokensTx.Amount, validator.Tokens),
+				Log:  fmt.Sprintf("Trying to stake more tokens than unstaked (attempted: %v, fee: %v, unstaked: %v)", tx.Amount, tx.Fee, account.Tokens),
 			}, errors.New("trying to stake more tokens than unstaked")
 		}
-		if stakeTokensTx.Amount < 0 && validator.GovernancePower+stakeTokensTx.Amount >= 0 {
+		// Staked tokens are the self delegation of the validator
+		selfDelegation := delegationTokens(validator, app.Delegations[tx.ValidatorAddress][tx.ValidatorAddress])
+		if tx.Unstake && tx.Amount.Gt(selfDelegation) {
 			return &types.ResponseCheckTx{
 				Code: CodeTypeNotEnoughStakedTokens,
-				Log:  fmt.Sprintf("Trying to unstake more tokens than staked (attemped: %d, staked: %d)", -stakeTokensTx.Amount, validator.GovernancePower),
+				Log:  fmt.Sprintf("Trying to unstake more tokens than staked (attemped: %v, staked: %v)", tx.Amount, selfDelegation),
 			}, errors.New("trying to unstake more tokens than staked")
 		}
 
-		verifier := ed25519.NewBatchVerifier()
-		hasher := sha256.New()
-		// We can also calculate the byte arrays more efficiently (e.g. using binary.LittleEndian) and write them seperately
-		// However this significantly increases code cluther with the convertion process and error handling
-		_, err = hasher.Write([]byte("Stake" + fmt.Sprintf("%#x", stakeTokensTx.Amount) + fmt.Sprintf("%#x", (validator.Nonce))))
-		if err != nil {
+	case *txcodec.ClaimTokensTx:
+		if !validAccountAddress(tx.ValidatorAddress) {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeInvalidSingature,
-				Log:  "Error hashing data for proof validation",
-			}, err
+				Code: CodeTypeInvalidAddress,
+				Log:  fmt.Sprintf("Tokens can only be claimed to a CometBFT or checksummed Ethereum address (attempted: %v)", tx.ValidatorAddress),
+			}, errors.New("invalid receiving address")
 		}
-		err = verifier.Add(validator.PubKey, hasher.Sum(nil), []byte(stakeTokensTx.Proof))
-		if err != nil {
+		if !validTransactionHash(tx.TransactionHash) {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeInvalidSingature,
-				Log:  "Error verifying proof",
-			}, err
+				Code: CodeTypeDepositNotVerified,
+				Log:  fmt.Sprintf("Transaction hash should be 0x followed by 64 lower case hex characters (attempted: %v)", tx.TransactionHash),
+			}, errors.New("invalid transaction hash")
 		}
-		valid, _ := verifier.Verify()
-		if !valid {
+		record, exists := app.Deposits[tx.TransactionHash]
+		if record.Claimed {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeInvalidSingature,
-				Log:  "Proof is not valid",
-			}, err
+				Code: CodeTypeDepositNotVerified,
+				Log:  fmt.Sprintf("Deposit is already claimed (attempted: %v)", tx.TransactionHash),
+			}, errors.New("deposit is already claimed")
 		}
-
-	case TransactionClaimTokens:
-		claimTokensTx := &ClaimTokensTx{}
-		err := json.Unmarshal(check.Tx, claimTokensTx)
-		if err != nil {
+		if execution && !exists {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeTransactionDecodingError,
-				Log:  fmt.Sprint("Not able to parse claim tokens transaction", "err", err),
-			}, err
+				Code: CodeTypeDepositNotVerified,
+				Log:  fmt.Sprintf("Deposit is not attested by the validators (attempted: %v)", tx.TransactionHash),
+			}, errors.New("deposit is not attested by the validators")
+		}
+		deposit := record.DepositItem
+		if !exists {
+			// The mempool accepts claims of deposits our xnode observed, they are attested before the claim is executed
+			deposit, exists = app.xnode.getDeposit(tx.TransactionHash)
+			if confirmations := app.xnode.getConfirmations(deposit); exists && confirmations < uint64(app.Params.DepositConfirmations) {
+				return &types.ResponseCheckTx{
+					Code: CodeTypeDepositNotVerified,
+					Log:  fmt.Sprintf("Deposit does not have enough confirmations yet (attempted: %v, confirmations: %d, required: %d)", tx.TransactionHash, confirmations, app.Params.DepositConfirmations),
+				}, errors.New("deposit does not have enough confirmations")
+			}
 		}
-
-		deposit, exists := verifiedDeposits[claimTokensTx.TransactionHash]
 		if !exists {
 			// Does this also need a timestamp to check if it's not too recent?
 			return &types.ResponseCheckTx{
 				Code: CodeTypeDepositNotVerified,
-				Log:  fmt.Sprintf("Deposit is not confirmed by our xnode (attempted: %v)", claimTokensTx.TransactionHash),
+				Log:  fmt.Sprintf("Deposit is not confirmed by our xnode (attempted: %v)", tx.TransactionHash),
 			}, errors.New("deposit is not confirmed by our xnode")
 		}
 
-		data := []byte("hello")
-		hash := eth.Keccak256Hash(data)
-		signerAddress, err := eth.Ecrecover(hash.Bytes(), []byte(claimTokensTx.Proof))
-		if err != nil || bytes.HexBytes(signerAddress).String() != deposit.Address {
+		signerAddress, err := claimSigner(app.ChainID, tx.TransactionHash, tx.ValidatorAddress, []byte(tx.Proof))
+		if err != nil || signerAddress != common.HexToAddress(deposit.Address) {
 			return &types.ResponseCheckTx{
 				Code: CodeTypeDepositInvalidSignature,
-				Log:  fmt.Sprintf("Signature does not match depositer address, it should be signed by: %v", deposit.Address),
+				Log:  fmt.Sprintf("Proof is not an EIP-712 claim signature of the depositor, it should be signed by: %v", deposit.Address),
+			}, errors.New("invalid claim signature")
+		}
+		if _, err := app.Accounts[tx.ValidatorAddress].Tokens.Add(deposit.Amount); err != nil {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeAmountOverflow,
+				Log:  fmt.Sprintf("Account %v can not hold %v more tokens", tx.ValidatorAddress, deposit.Amount),
 			}, err
 		}
 
-	case TransactionWithdrawTokens:
-		withdrawTokensTx := &WithdrawTokensTx{}
-		err := json.Unmarshal(check.Tx, withdrawTokensTx)
-		if err != nil {
+	case *txcodec.WithdrawTokensTx:
+		if check, err := app.verifySignedTx(tx); check != nil {
+			return check, err
+		}
+
+		if !isEthereumAddress(tx.Address) || !validAccountAddress(tx.Address) {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeTransactionDecodingError,
-				Log:  fmt.Sprint("Not able to parse withdraw tokens transaction", "err", err),
-			}, err
+				Code: CodeTypeInvalidAddress,
+				Log:  fmt.Sprintf("Tokens can only be withdrawn to a checksummed Ethereum address (attempted: %v)", tx.Address),
+			}, errors.New("invalid withdraw address")
+		}
+		account := app.Accounts[tx.ValidatorAddress]
+		if tx.Amount.IsZero() {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeNotEnoughUnstakedTokens,
+				Log:  "Withdraw amount should be positive",
+			}, errors.New("withdraw amount should be positive")
+		}
+		if tx.Amount.Gt(spendableTokens(tx, account)) {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeNotEnoughUnstakedTokens,
+				Log:  fmt.Sprintf("Trying to withdraw more tokens than unstaked (attempted: %v, fee: %v, unstaked: %v)", tx.Amount, tx.Fee, account.Tokens),
+			}, errors.New("trying to withdraw more tokens than unstaked")
 		}
 
-		validator := app.Validators[withdrawTokensTx.ValidatorAddress]
-		if withdrawTokensTx.Amount > validator.Tokens {
+	case *txcodec.CreateValidatorTx:
+		if len(tx.PubKey) != ed25519.PubKeySize {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeInvalidValidator,
+				Log:  fmt.Sprintf("Public key should be an ed25519 key of %d bytes (attempted: %d bytes)", ed25519.PubKeySize, len(tx.PubKey)),
+			}, errors.New("public key should be an ed25519 key")
+		}
+		if _, exists := app.Validators[tx.Signer()]; exists {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeValidatorExists,
+				Log:  fmt.Sprintf("Validator %v already exists", tx.Signer()),
+			}, errors.New("validator already exists")
+		}
+		if len(tx.Moniker) > maxMonikerLength {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeInvalidValidator,
+				Log:  fmt.Sprintf("Moniker is too long (attempted: %d, maximum: %d)", len(tx.Moniker), maxMonikerLength),
+			}, errors.New("moniker is too long")
+		}
+		if tx.CommissionRate > maxCommissionRate {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeInvalidValidator,
+				Log:  fmt.Sprintf("Commission rate is too high (attempted: %d, maximum: %d)", tx.CommissionRate, maxCommissionRate),
+			}, errors.New("commission rate is too high")
+		}
+		if check, err := app.verifySignedTx(tx); check != nil {
+			return check, err
+		}
+
+	case *txcodec.DelegateTx:
+		if check, err := app.verifySignedTx(tx); check != nil {
+			return check, err
+		}
+
+		validator, exists := app.Validators[tx.ValidatorAddress]
+		if !exists || validator.Jailed || validator.GovernancePower.Lt(minimumValidatorPower) {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeInvalidValidator,
+				Log:  fmt.Sprintf("Validator %v is not active", tx.ValidatorAddress),
+			}, errors.New("validator is not active")
+		}
+		if tx.Amount.IsZero() {
 			return &types.ResponseCheckTx{
 				Code: CodeTypeNotEnoughUnstakedTokens,
-				Log:  fmt.Sprintf("Trying to stake more tokens than unstaked (attempted: %d, unstaked: %d)", withdrawTokensTx.Amount, validator.Tokens),
-			}, errors.New("trying to stake more tokens than unstaked")
+				Log:  "Delegate amount should be positive",
+			}, errors.New("delegate amount should be positive")
+		}
+		delegator := app.Accounts[tx.DelegatorAddress]
+		if tx.Amount.Gt(spendableTokens(tx, delegator)) {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeNotEnoughUnstakedTokens,
+				Log:  fmt.Sprintf("Trying to delegate more tokens than unstaked (attempted: %v, fee: %v, unstaked: %v)", tx.Amount, tx.Fee, delegator.Tokens),
+			}, errors.New("trying to delegate more tokens than unstaked")
+		}
+		if shares, err := tx.Amount.MulDiv(validator.DelegatorShares, validator.GovernancePower, false); err != nil || shares.IsZero() {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeNotEnoughUnstakedTokens,
+				Log:  fmt.Sprintf("Delegate amount is worth no shares (attempted: %v)", tx.Amount),
+			}, errors.New("delegate amount is worth no shares")
 		}
 
-		verifier := ed25519.NewBatchVerifier()
-		hasher := sha256.New()
-		_, err = hasher.Write([]byte("Withdraw" + fmt.Sprintf("%#x", withdrawTokensTx.Amount) + withdrawTokensTx.Address + fmt.Sprintf("%#x", (validator.Nonce))))
-		if err != nil {
+	case *txcodec.UndelegateTx:
+		if check, err := app.verifySignedTx(tx); check != nil {
+			return check, err
+		}
+
+		shares := app.Delegations[tx.ValidatorAddress][tx.DelegatorAddress]
+		if tx.Shares.IsZero() || tx.Shares.Gt(shares) {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeInvalidSingature,
-				Log:  "Error hashing data for proof validation",
-			}, err
+				Code: CodeTypeNotEnoughStakedTokens,
+				Log:  fmt.Sprintf("Trying to undelegate more shares than delegated (attempted: %v, delegated: %v)", tx.Shares, shares),
+			}, errors.New("trying to undelegate more shares than delegated")
 		}
-		err = verifier.Add(validator.PubKey, hasher.Sum(nil), []byte(withdrawTokensTx.Proof))
-		if err != nil {
+
+	case *txcodec.UnjailTx:
+		if check, err := app.verifySignedTx(tx); check != nil {
+			return check, err
+		}
+
+		validator := app.Validators[tx.ValidatorAddress]
+		if !validator.Jailed {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeInvalidSingature,
-				Log:  "Error verifying proof",
-			}, err
+				Code: CodeTypeInvalidValidator,
+				Log:  fmt.Sprintf("Validator %v is not jailed", tx.ValidatorAddress),
+			}, errors.New("validator is not jailed")
+		}
+		if validator.Tombstoned {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeValidatorJailed,
+				Log:  fmt.Sprintf("Validator %v double signed and can never unjail", tx.ValidatorAddress),
+			}, errors.New("validator is tombstoned")
+		}
+		if now.Unix() < validator.JailedUntil {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeValidatorJailed,
+				Log:  fmt.Sprintf("Validator %v is jailed until %d", tx.ValidatorAddress, validator.JailedUntil),
+			}, errors.New("validator is still jailed")
+		}
+		if validator.GovernancePower.Lt(minimumValidatorPower) {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeNotEnoughStakedTokens,
+				Log:  fmt.Sprintf("Validator %v should have at least %v staked to unjail (staked: %v)", tx.ValidatorAddress, minimumValidatorPower, validator.GovernancePower),
+			}, errors.New("not enough staked to unjail")
+		}
+
+	case *txcodec.WithdrawRewardsTx:
+		if check, err := app.verifySignedTx(tx); check != nil {
+			return check, err
 		}
-		valid, _ := verifier.Verify()
-		if !valid {
+
+		account := app.Accounts[tx.Address]
+		if account.Rewards.IsZero() {
 			return &types.ResponseCheckTx{
-				Code: CodeTypeInvalidSingature,
-				Log:  "Proof is not valid",
+				Code: CodeTypeNoRewards,
+				Log:  fmt.Sprintf("Account %v has no rewards to withdraw", tx.Address),
+			}, errors.New("no rewards to withdraw")
+		}
+		if _, err := account.Tokens.Add(account.Rewards); err != nil {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeAmountOverflow,
+				Log:  fmt.Sprintf("Account %v can not hold %v more tokens", tx.Address, account.Rewards),
 			}, err
 		}
 
+	case *txcodec.SendTx:
+		if check, err := app.verifySignedTx(tx); check != nil {
+			return check, err
+		}
+
+		if !validAccountAddress(tx.ToAddress) {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeInvalidAddress,
+				Log:  fmt.Sprintf("Tokens can only be sent to a CometBFT or checksummed Ethereum address (attempted: %v)", tx.ToAddress),
+			}, errors.New("invalid recipient address")
+		}
+		account := app.Accounts[tx.FromAddress]
+		if tx.Amount.IsZero() {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeNotEnoughUnstakedTokens,
+				Log:  "Send amount should be positive",
+			}, errors.New("send amount should be positive")
+		}
+		if tx.Amount.Gt(spendableTokens(tx, account)) {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeNotEnoughUnstakedTokens,
+				Log:  fmt.Sprintf("Trying to send more tokens than unstaked (attempted: %v, fee: %v, unstaked: %v)", tx.Amount, tx.Fee, account.Tokens),
+			}, errors.New("trying to send more tokens than unstaked")
+		}
+		if _, err := app.Accounts[tx.ToAddress].Tokens.Add(tx.Amount); tx.ToAddress != tx.FromAddress && err != nil {
+			return &types.ResponseCheckTx{
+				Code: CodeTypeAmountOverflow,
+				Log:  fmt.Sprintf("Account %v can not hold %v more tokens", tx.ToAddress, tx.Amount),
+			}, err
+		}
 	}
 
 	return &types.ResponseCheckTx{Code: CodeTypeOK}, nil
 }
 
 func (app *Application) InitChain(_ context.Context, chain *types.RequestInitChain) (*types.ResponseInitChain, error) {
+	app.ChainID = chain.ChainId
+	genesis, err := parseGenesisState(chain.AppStateBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing genesis: %w", err)
+	}
+	app.Params = genesis.Params
+	app.InflationTime = chain.Time
+
+	// Genesis power is consensus power, the validators stake the tokens it is worth
 	for i := 0; i < len(chain.Validators); i++ {
 		pk := ed25519.PubKey(chain.Validators[i].PubKey.GetEd25519())
+		stake := app.Params.powerTokens(chain.Validators[i].Power)
+		app.Accounts[pk.Address().String()] = Account{
+			AccountNumber: app.newAccountNumber(),
+			PubKey:        pk,
+		}
+		app.markDirty(accountPrefix + pk.Address().String())
 		app.Validators[pk.Address().String()] = AbciValidator{
 			PubKey:          pk,
-			GovernancePower: chain.Validators[i].Power,
-			Tokens:          0,
+			GovernancePower: stake,
+			DelegatorShares: stake,
 		}
+		app.markDirty(validatorPrefix + pk.Address().String())
+		app.Delegations[pk.Address().String()] = map[string]u256.Int{pk.Address().String(): stake}
+		app.markDirty(delegationPrefix + pk.Address().String() + "/" + pk.Address().String())
+	}
+	// Replaces the genesis validators, as validators below the minimum stake or beyond Params.MaxValidators are not active
+	validators := app.updateActiveSet()
+	if len(validators) == 0 {
+		return nil, fmt.Errorf("no genesis validator has the minimum power of %d", app.Params.consensusPower(minimumValidatorPower))
 	}
-	return &types.ResponseInitChain{}, nil
+
+	appHash, err := app.finalizeState()
+	if err != nil {
+		return nil, fmt.Errorf("calculating genesis app hash: %w", err)
+	}
+	return &types.ResponseInitChain{Validators: validators, AppHash: appHash}, nil
 }
 
-func (app *Application) FinalizeBlock(context context.Context, req *types.RequestFinalizeBlock) (*types.ResponseFinalizeBlock, error) {
+func (app *Application) FinalizeBlock(_ context.Context, req *types.RequestFinalizeBlock) (*types.ResponseFinalizeBlock, error) {
+	app.finalizedHeight = req.Height
+
 	// Process transactions
 	txs := make([]*types.ExecTxResult, len(req.Txs))
-	events := make([]types.Event, 0, len(req.Txs)) // Change if a proposal can have more than 1 event
+	block := newBlockContext(req.Height, req.Time, req.ProposerAddress, &req.DecidedLastCommit)
 	for i := 0; i < len(req.Txs); i++ {
-		// Check again as state changes between mempool addition and process could have invalidated it
-		check, _ := app.CheckTx(context, &types.RequestCheckTx{Tx: req.Txs[i]})
-		if check.Code != CodeTypeOK {
-			txs[i] = &types.ExecTxResult{
-				Code: check.Code,
-				Log:  check.Log,
-			}
-			continue
-		}
+		txs[i] = app.deliverTx(req.Txs[i], block)
+	}
+	events := make([]types.Event, 0)
 
-		tx := &Transaction{}
-		err := json.Unmarshal(req.Txs[i], tx)
-		if err != nil {
-			txs[i] = &types.ExecTxResult{
-				Code: CodeTypeTransactionDecodingError,
-				Log:  check.Log,
-			}
-			continue
-		}
+	// Calculate block rewards (lagging behind 1 block, cannot know already who votes on this block obviously)
+	// Only validators that signed the last block are rewarded
+	events = append(events, app.distributeRewards(block)...)
+	for i := 0; i < len(req.DecidedLastCommit.Votes); i++ {
+		events = append(events, app.trackLiveness(req.DecidedLastCommit.Votes[i], block)...)
+	}
+	for i := 0; i < len(req.Misbehavior); i++ {
+		events = append(events, app.punishMisbehavior(req.Misbehavior[i], block)...)
+	}
 
-		switch tx.TransactionType {
-		case TransactionValidateData:
-			validateDataTx := &ValidateDataTx{}
-			err := json.Unmarshal(req.Txs[i], validateDataTx)
-			if err != nil {
-				txs[i] = &types.ExecTxResult{
-					Code: CodeTypeTransactionTypeDecodingError,
-					Log:  check.Log,
-				}
-				continue
-			}
+	// Validators that staked, unstaked, were slashed or unjailed enter or leave the active set
+	validatorUpdates := app.updateActiveSet()
 
-			app.VerifiedData[validateDataTx.DataFeed] = VerifiedDataItem{Data: validateDataTx.DataValue, Timestamp: validateDataTx.DataTimestamp}
+	// Unbonding tokens are returned after the slashing of this block
+	events = append(events, app.matureUnbondings(block)...)
 
-			event := types.Event{Type: "Data Verified", Attributes: make([]types.EventAttribute, 3)}
-			event.Attributes[0] = types.EventAttribute{Key: "feed", Value: fmt.Sprintf("%v", validateDataTx.DataFeed)}
-			event.Attributes[1] = types.EventAttribute{Key: "data", Value: fmt.Sprintf("%v", validateDataTx.DataValue)}
-			event.Attributes[2] = types.EventAttribute{Key: "timestamp", Value: fmt.Sprintf("%d", validateDataTx.DataTimestamp)}
-			events = append(events, event)
+	app.pruneAttestations()
 
-		case TransactionStakeTokens:
-			stakeTokensTx := &StakeTokensTx{}
-			err := json.Unmarshal(req.Txs[i], stakeTokensTx)
-			if err != nil {
-				txs[i] = &types.ExecTxResult{
-					Code: CodeTypeTransactionTypeDecodingError,
-					Log:  check.Log,
-				}
-				continue
-			}
+	// Commitment to the resulting state, nodes that executed the block differently will disagree on the next block
+	appHash, err := app.finalizeState()
+	if err != nil {
+		return nil, fmt.Errorf("calculating app hash at height %d: %w", req.Height, err)
+	}
+	app.finalizedAppHash = appHash
 
-			validator := app.Validators[stakeTokensTx.ValidatorAddress]
+	return &types.ResponseFinalizeBlock{TxResults: txs, ValidatorUpdates: validatorUpdates, Events: events, AppHash: appHash}, nil
+}
 
-			// Amount can be negative to unstake
-			validator.GovernancePower += stakeTokensTx.Amount
-			validator.Tokens -= stakeTokensTx.Amount
+func (app *Application) Commit(_ context.Context, commit *types.RequestCommit) (*types.ResponseCommit, error) {
+	app.LastBlockHeight = app.finalizedHeight
+	app.LastBlockAppHash = app.finalizedAppHash
+	if err := app.saveState(); err != nil {
+		return nil, fmt.Errorf("saving state at height %d: %w", app.LastBlockHeight, err)
+	}
+	app.xnode.pruneData(app.VerifiedData, app.AttestedData)
 
-			// Only relevant if amount is negative
-			if validator.GovernancePower < minimumValidatorPower {
-				// If their GovernancePower is bellow the threshold, move all to tokens and give them GovernancePower 0
-				validator.Tokens += validator.GovernancePower
-				validator.GovernancePower = 0
-			}
+	if app.SnapshotInterval > 0 && uint64(app.LastBlockHeight)%app.SnapshotInterval == 0 {
+		// Not being able to serve snapshots should not halt the chain
+		if err := app.createSnapshot(); err != nil {
+			log.Printf("Creating snapshot at height %d failed: %v", app.LastBlockHeight, err)
+		}
+	}
+	return &types.ResponseCommit{}, nil
+}
 
-			validator.Nonce++
+// Executes a transaction on the current state, the result contains the events it emitted so CometBFT indexes them with the transaction
+func (app *Application) deliverTx(transaction []byte, block *blockContext) *types.ExecTxResult {
+	events := make([]types.Event, 0, 1)
+	tx, err := app.decodeTx(transaction)
+	if err != nil {
+		check := decodeError(err)
+		return &types.ExecTxResult{
+			Code: check.Code,
+			Log:  check.Log,
+		}
+	}
 
-			app.Validators[stakeTokensTx.ValidatorAddress] = validator
+	// Check again as state changes between mempool addition and process could have invalidated it
+	check, _ := app.checkTx(tx, block)
+	if check.Code != CodeTypeOK {
+		return &types.ExecTxResult{
+			Code: check.Code,
+			Log:  check.Log,
+		}
+	}
 
-			event := types.Event{Type: "Tokens Staked", Attributes: make([]types.EventAttribute, 2)}
-			event.Attributes[0] = types.EventAttribute{Key: "validator", Value: fmt.Sprintf("%v", stakeTokensTx.ValidatorAddress)}
-			event.Attributes[1] = types.EventAttribute{Key: "amount", Value: fmt.Sprintf("%d", stakeTokensTx.Amount)}
-			events = append(events, event)
-			// Do we want to include the proof in here too?
+	if signedTx, signed := tx.(txcodec.SignedTx); signed {
+		app.chargeSignedTx(signedTx, block)
+	}
 
-		case TransactionClaimTokens:
-			claimTokensTx := &ClaimTokensTx{}
-			err := json.Unmarshal(req.Txs[i], claimTokensTx)
-			if err != nil {
-				txs[i] = &types.ExecTxResult{
-					Code: CodeTypeTransactionTypeDecodingError,
-					Log:  check.Log,
-				}
-				continue
+	switch tx := tx.(type) {
+	case *txcodec.AttestDataTx:
+		if block.Attested {
+			return &types.ExecTxResult{
+				Code: CodeTypeDataAttestation,
+				Log:  "Block already contains attestations",
 			}
-
-			validator := app.Validators[claimTokensTx.ValidatorAddress]
-
-			deposit := verifiedDeposits[claimTokensTx.TransactionHash]
-			validator.Tokens += deposit.Amount
-			delete(verifiedDeposits, claimTokensTx.TransactionHash) // Prevent deposit from being claimed again
-
-			app.Validators[claimTokensTx.ValidatorAddress] = validator
-
-			event := types.Event{Type: "Token Claimed", Attributes: make([]types.EventAttribute, 2)}
-			event.Attributes[0] = types.EventAttribute{Key: "validator", Value: fmt.Sprintf("%v", claimTokensTx.ValidatorAddress)}
-			event.Attributes[1] = types.EventAttribute{Key: "transactionhash", Value: fmt.Sprintf("%v", claimTokensTx.TransactionHash)}
-			events = append(events, event)
-			// Do we want to include the proof in here too?
-			// Do we want to inlcude deposit info (you can check that on Ethereum with transaction hash tho)
-
-		case TransactionWithdrawTokens:
-			withdrawTokensTx := &WithdrawTokensTx{}
-			err := json.Unmarshal(req.Txs[i], withdrawTokensTx)
-			if err != nil {
-				txs[i] = &types.ExecTxResult{
-					Code: check.Code,
-					Log:  check.Log,
-				}
-				continue
+		}
+		attested, err := app.aggregateAttestations(block.Height, &tx.Commit, block.LastCommit)
+		if err != nil {
+			return &types.ExecTxResult{
+				Code: CodeTypeDataAttestation,
+				Log:  fmt.Sprint("Invalid attestations", "err", err),
 			}
-
-			validator := app.Validators[withdrawTokensTx.ValidatorAddress]
-
-			validator.Tokens -= withdrawTokensTx.Amount
-
-			validator.Nonce++
-
-			app.Validators[withdrawTokensTx.ValidatorAddress] = validator
-
-			event := types.Event{Type: "Tokens Withdrawn", Attributes: make([]types.EventAttribute, 2)}
-			event.Attributes[0] = types.EventAttribute{Key: "validator", Value: fmt.Sprintf("%v", withdrawTokensTx.ValidatorAddress)}
-			event.Attributes[1] = types.EventAttribute{Key: "amount", Value: fmt.Sprintf("%d", withdrawTokensTx.Amount)}
-			events = append(events, event)
-			// Do we want to include the proof in here too?
-
+		}
+		app.storeAttestations(attested, block.Height)
+		block.Attested = true
+
+		event := types.Event{Type: "Data Attested", Attributes: make([]types.EventAttribute, 3)}
+		event.Attributes[0] = types.EventAttribute{Key: "observations", Value: fmt.Sprintf("%d", len(attested.Data))}
+		event.Attributes[1] = types.EventAttribute{Key: "deposits", Value: fmt.Sprintf("%d", len(attested.Deposits))}
+		event.Attributes[2] = types.EventAttribute{Key: "retractions", Value: fmt.Sprintf("%d", len(attested.Retractions))}
+		events = append(events, event)
+
+	case *txcodec.ValidateDataTx:
+		app.VerifiedData[tx.DataFeed] = VerifiedDataItem{Data: tx.DataValue, Timestamp: tx.DataTimestamp}
+		app.markDirty(dataFeedPrefix + tx.DataFeed)
+
+		event := types.Event{Type: "Data Verified", Attributes: make([]types.EventAttribute, 3)}
+		event.Attributes[0] = types.EventAttribute{Key: "feed", Value: fmt.Sprintf("%v", tx.DataFeed)}
+		event.Attributes[1] = types.EventAttribute{Key: "data", Value: fmt.Sprintf("%v", tx.DataValue)}
+		event.Attributes[2] = types.EventAttribute{Key: "timestamp", Value: fmt.Sprintf("%d", tx.DataTimestamp)}
+		events = append(events, event)
+
+	case *txcodec.StakeTokensTx:
+		validator := app.Validators[tx.ValidatorAddress]
+
+		// Staking is delegating to yourself
+		if !tx.Unstake && !tx.Amount.IsZero() {
+			app.delegate(tx.ValidatorAddress, tx.ValidatorAddress, tx.Amount)
+		} else if tx.Unstake && !tx.Amount.IsZero() {
+			shares := delegationShares(validator, tx.Amount).Min(app.Delegations[tx.ValidatorAddress][tx.ValidatorAddress])
+			app.undelegate(tx.ValidatorAddress, tx.ValidatorAddress, shares, block)
 		}
 
-		app.TotalTransactions++
-		txs[i] = &types.ExecTxResult{Code: CodeTypeOK}
-	}
-
-	// Calculate block rewards (lagging behind 1 block, cannot know already who votes on this block obviously)
-	// This assumes all punished validators are still validating though!
-	blockRewards := make([]types.ValidatorUpdate, len(req.DecidedLastCommit.Votes)+len(req.Misbehavior))
-	for i := 0; i < len(req.DecidedLastCommit.Votes); i++ {
-		address := bytes.HexBytes(req.DecidedLastCommit.Votes[i].Validator.Address).String()
-		validator := app.Validators[address]
-
-		validator.GovernancePower += validator.GovernancePower / 10000
-		blockRewards[i] = types.Ed25519ValidatorUpdate(validator.PubKey.Bytes(), validator.GovernancePower)
+		// Only relevant when unstaking
+		// If their GovernancePower is bellow the threshold, return all delegations and give them GovernancePower 0
+		app.removeBelowMinimum(tx.ValidatorAddress, block)
 
-		app.Validators[address] = validator
-	}
-	for i := 0; i < len(req.Misbehavior); i++ {
-		address := bytes.HexBytes(req.Misbehavior[i].Validator.Address).String()
-		validator := app.Validators[address]
+		event := types.Event{Type: "Tokens Staked", Attributes: make([]types.EventAttribute, 3)}
+		event.Attributes[0] = types.EventAttribute{Key: "validator", Value: fmt.Sprintf("%v", tx.ValidatorAddress)}
+		event.Attributes[1] = types.EventAttribute{Key: "amount", Value: tx.Amount.String()}
+		event.Attributes[2] = types.EventAttribute{Key: "unstake", Value: fmt.Sprintf("%v", tx.Unstake)}
+		events = append(events, event)
+		// Do we want to include the proof in here too?
 
-		// Can a validator withdraw their governance power before the evidence against their actions is finalized to prevent punishment?
-		validator.GovernancePower -= validator.GovernancePower / 100
-		if validator.GovernancePower < minimumValidatorPower {
-			// If their GovernancePower is bellow the threshold, move all to tokens and give them GovernancePower 0
-			validator.Tokens += validator.GovernancePower
-			validator.GovernancePower = 0
+	case *txcodec.ClaimTokensTx:
+		account, exists := app.Accounts[tx.ValidatorAddress]
+		if !exists {
+			account.AccountNumber = app.newAccountNumber()
 		}
-		blockRewards[len(req.DecidedLastCommit.Votes)+i] = types.Ed25519ValidatorUpdate(validator.PubKey.Bytes(), validator.GovernancePower)
 
-		app.Validators[address] = validator
+		deposit := app.Deposits[tx.TransactionHash]
+		account.Tokens = u256.Must(account.Tokens.Add(deposit.Amount)) // Checked by checkTx
+		deposit.Claimed = true                                         // Prevent deposit from being claimed again
+		deposit.ClaimedHeight = block.Height
+		deposit.Receiver = tx.ValidatorAddress
+		app.Deposits[tx.TransactionHash] = deposit
+		app.markDirty(depositPrefix + tx.TransactionHash)
+
+		app.Accounts[tx.ValidatorAddress] = account
+		app.markDirty(accountPrefix + tx.ValidatorAddress)
+
+		event := types.Event{Type: "Token Claimed", Attributes: make([]types.EventAttribute, 2)}
+		event.Attributes[0] = types.EventAttribute{Key: "validator", Value: fmt.Sprintf("%v", tx.ValidatorAddress)}
+		event.Attributes[1] = types.EventAttribute{Key: "transactionhash", Value: fmt.Sprintf("%v", tx.TransactionHash)}
+		events = append(events, event)
+		// Do we want to include the proof in here too?
+		// Do we want to inlcude deposit info (you can check that on Ethereum with transaction hash tho)
+
+	case *txcodec.WithdrawTokensTx:
+		nonce := app.withdraw(tx.ValidatorAddress, tx.Address, tx.Amount, block)
+
+		event := types.Event{Type: "Tokens Withdrawn", Attributes: make([]types.EventAttribute, 4)}
+		event.Attributes[0] = types.EventAttribute{Key: "validator", Value: fmt.Sprintf("%v", tx.ValidatorAddress)}
+		event.Attributes[1] = types.EventAttribute{Key: "amount", Value: tx.Amount.String()}
+		event.Attributes[2] = types.EventAttribute{Key: "withdrawer", Value: tx.Address}
+		event.Attributes[3] = types.EventAttribute{Key: "nonce", Value: fmt.Sprintf("%d", nonce)}
+		events = append(events, event)
+
+	case *txcodec.CreateValidatorTx:
+		app.Validators[tx.Signer()] = AbciValidator{
+			PubKey:         tx.PubKey,
+			Moniker:        tx.Moniker,
+			CommissionRate: tx.CommissionRate,
+		}
+		app.markDirty(validatorPrefix + tx.Signer())
+		account := app.Accounts[tx.Signer()]
+		account.PubKey = tx.PubKey
+		app.Accounts[tx.Signer()] = account
+		app.markDirty(accountPrefix + tx.Signer())
+
+		event := types.Event{Type: "Validator Created", Attributes: make([]types.EventAttribute, 3)}
+		event.Attributes[0] = types.EventAttribute{Key: "validator", Value: tx.Signer()}
+		event.Attributes[1] = types.EventAttribute{Key: "moniker", Value: tx.Moniker}
+		event.Attributes[2] = types.EventAttribute{Key: "commissionrate", Value: fmt.Sprintf("%d", tx.CommissionRate)}
+		events = append(events, event)
+
+	case *txcodec.DelegateTx:
+		shares := app.delegate(tx.DelegatorAddress, tx.ValidatorAddress, tx.Amount)
+
+		event := types.Event{Type: "Tokens Delegated", Attributes: make([]types.EventAttribute, 4)}
+		event.Attributes[0] = types.EventAttribute{Key: "delegator", Value: tx.DelegatorAddress}
+		event.Attributes[1] = types.EventAttribute{Key: "validator", Value: tx.ValidatorAddress}
+		event.Attributes[2] = types.EventAttribute{Key: "amount", Value: tx.Amount.String()}
+		event.Attributes[3] = types.EventAttribute{Key: "shares", Value: shares.String()}
+		events = append(events, event)
+
+	case *txcodec.UndelegateTx:
+		amount := app.undelegate(tx.DelegatorAddress, tx.ValidatorAddress, tx.Shares, block)
+		app.removeBelowMinimum(tx.ValidatorAddress, block)
+
+		event := types.Event{Type: "Tokens Undelegated", Attributes: make([]types.EventAttribute, 5)}
+		event.Attributes[0] = types.EventAttribute{Key: "delegator", Value: tx.DelegatorAddress}
+		event.Attributes[1] = types.EventAttribute{Key: "validator", Value: tx.ValidatorAddress}
+		event.Attributes[2] = types.EventAttribute{Key: "shares", Value: tx.Shares.String()}
+		event.Attributes[3] = types.EventAttribute{Key: "amount", Value: amount.String()}
+		event.Attributes[4] = types.EventAttribute{Key: "completiontime", Value: fmt.Sprintf("%d", block.Time.Unix()+app.Params.UnbondingPeriod)}
+		events = append(events, event)
+
+	case *txcodec.UnjailTx:
+		validator := app.Validators[tx.ValidatorAddress]
+		validator.Jailed = false
+		validator.JailedUntil = 0
+		app.Validators[tx.ValidatorAddress] = validator
+		app.markDirty(validatorPrefix + tx.ValidatorAddress)
+		delete(app.SigningInfos, tx.ValidatorAddress) // Liveness is tracked again from the next commit
+		app.markDirty(signingInfoPrefix + tx.ValidatorAddress)
+
+		event := types.Event{Type: "Validator Unjailed", Attributes: make([]types.EventAttribute, 1)}
+		event.Attributes[0] = types.EventAttribute{Key: "validator", Value: tx.ValidatorAddress}
+		events = append(events, event)
+
+	case *txcodec.WithdrawRewardsTx:
+		account := app.Accounts[tx.Address]
+		amount := account.Rewards
+		account.Tokens = u256.Must(account.Tokens.Add(amount)) // Checked by checkTx
+		account.Rewards = u256.Int{}
+		app.Accounts[tx.Address] = account
+		app.markDirty(accountPrefix + tx.Address)
+
+		event := types.Event{Type: "Rewards Withdrawn", Attributes: make([]types.EventAttribute, 2)}
+		event.Attributes[0] = types.EventAttribute{Key: "address", Value: tx.Address}
+		event.Attributes[1] = types.EventAttribute{Key: "amount", Value: amount.String()}
+		events = append(events, event)
+
+	case *txcodec.SendTx:
+		app.transfer(tx.FromAddress, tx.ToAddress, tx.Amount)
+		events = append(events, transferEvent(tx.FromAddress, tx.ToAddress, tx.Amount))
 	}
-	return &types.ResponseFinalizeBlock{TxResults: txs, ValidatorUpdates: blockRewards, Events: events}, nil
+
+	app.TotalTransactions++
+	return &types.ExecTxResult{Code: CodeTypeOK, Events: events}
 }
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	cmttypes "github.com/cometbft/cometbft/types"
+
+	"tendermint-app/txcodec"
+	"tendermint-app/u256"
+)
+
+const (
+	testChainID        = "test-chain"
+	testValidatorPower = 10_000 // Minimum stake with the default power reduction
+	testDepositHash    = "0x3d1b6a8e4b7f0c2e9a1d5f6c8b0e2a4d6f8c1e3a5b7d9f0e2c4a6b8d0f1e3a5c"
+	testDepositor      = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+	testDepositBlock   = "0x8c2f4e6a0b1d3f5a7c9e1b3d5f7a9c0e2b4d6f8a1c3e5b7d9f0a2c4e6b8d1f3a"
+)
+
+var testDeposit = DepositItem{Address: testDepositor, Amount: u256.New(5), BlockNumber: 100, BlockHash: testDepositBlock}
+
+func newTestApplication(t *testing.T, validatorKeys []ed25519.PrivKey) *Application {
+	t.Helper()
+	app, err := NewApplication(dbm.NewMemDB())
+	if err != nil {
+		t.Fatalf("creating application: %v", err)
+	}
+
+	validators := make([]types.ValidatorUpdate, len(validatorKeys))
+	for i, key := range validatorKeys {
+		validators[i] = types.Ed25519ValidatorUpdate(key.PubKey().Bytes(), testValidatorPower)
+	}
+	if _, err := app.InitChain(context.Background(), &types.RequestInitChain{ChainId: testChainID, Validators: validators}); err != nil {
+		t.Fatalf("initializing chain: %v", err)
+	}
+	return app
+}
+
+// Serializes the whole state, to compare the incrementally maintained state against a full rebuild
+func testStateEntries(t *testing.T, app *Application) map[string][]byte {
+	t.Helper()
+	keys := append([]string{}, scalarKeys...)
+	keys = appendStateKeys(keys, accountPrefix, app.Accounts)
+	keys = appendStateKeys(keys, validatorPrefix, app.Validators)
+	keys = appendStateKeys(keys, dataFeedPrefix, app.VerifiedData)
+	keys = appendStateKeys(keys, attestedPrefix, app.AttestedData)
+	keys = appendStateKeys(keys, depositPrefix, app.Deposits)
+	keys = appendStateKeys(keys, withdrawalPrefix, app.Withdrawals)
+	keys = appendStateKeys(keys, unbondingPrefix, app.Unbondings)
+	keys = appendStateKeys(keys, signingInfoPrefix, app.SigningInfos)
+	keys = appendStateKeys(keys, activeSetPrefix, app.ActiveSet)
+	for validatorAddress, delegations := range app.Delegations {
+		keys = appendStateKeys(keys, delegationPrefix+validatorAddress+"/", delegations)
+	}
+
+	entries := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		value, _, err := app.stateEntry(key)
+		if err != nil {
+			t.Fatalf("serializing state: %v", err)
+		}
+		entries[key] = value
+	}
+	return entries
+}
+
+func appendStateKeys[V any](keys []string, prefix string, items map[string]V) []string {
+	for key := range items {
+		keys = append(keys, prefix+key)
+	}
+	return keys
+}
+
+// Commit of the previous block in which every validator attested the same vote extension
+func testExtendedCommit(t *testing.T, validatorKeys []ed25519.PrivKey, height int64, extension VoteExtension) types.ExtendedCommitInfo {
+	t.Helper()
+	voteExtension, err := json.Marshal(extension)
+	if err != nil {
+		t.Fatalf("encoding vote extension: %v", err)
+	}
+
+	commit := types.ExtendedCommitInfo{}
+	for _, key := range validatorKeys {
+		signature, err := key.Sign(cmttypes.VoteExtensionSignBytes(testChainID, &cmtproto.Vote{Height: height - 1, Extension: voteExtension}))
+		if err != nil {
+			t.Fatalf("signing vote extension: %v", err)
+		}
+		commit.Votes = append(commit.Votes, types.ExtendedVoteInfo{
+			Validator:          types.Validator{Address: key.PubKey().Address(), Power: testValidatorPower},
+			VoteExtension:      voteExtension,
+			ExtensionSignature: signature,
+			BlockIdFlag:        cmtproto.BlockIDFlagCommit,
+		})
+	}
+	return commit
+}
+
+func testTransaction(t *testing.T, tx txcodec.Tx) []byte {
+	t.Helper()
+	encoded, err := txcodec.Encode(tx)
+	if err != nil {
+		t.Fatalf("encoding transaction: %v", err)
+	}
+	return encoded
+}
+
+// Signs a transaction of an ed25519 account with its current nonce
+func testSignedTransaction(t *testing.T, app *Application, key ed25519.PrivKey, tx txcodec.SignedTx) []byte {
+	t.Helper()
+	signer := app.Accounts[tx.Signer()]
+	if _, create := tx.(*txcodec.CreateValidatorTx); !create {
+		tx.Authentication().PubKey = key.PubKey().Bytes() // Create validator is signed by its consensus key instead
+	}
+	signDoc, err := txcodec.SignDocOf(app.ChainID, signer.AccountNumber, signer.Nonce, tx)
+	if err != nil {
+		t.Fatalf("creating sign doc: %v", err)
+	}
+	tx.Authentication().Signature, err = key.Sign(signDoc.SignBytes())
+	if err != nil {
+		t.Fatalf("signing transaction: %v", err)
+	}
+	return testTransaction(t, tx)
+}
+
+// Two nodes with different clocks and xnodes that disagree with each other have to execute the same block identically
+func TestFinalizeBlockDeterministic(t *testing.T) {
+	ctx := context.Background()
+	validatorKeys := []ed25519.PrivKey{ed25519.GenPrivKey(), ed25519.GenPrivKey(), ed25519.GenPrivKey(), ed25519.GenPrivKey()}
+	blockTime := time.Unix(1_700_000_000, 0)
+	dataTimestamp := uint64(blockTime.Unix()) - 10
+
+	early := newTestApplication(t, validatorKeys)
+	early.now = func() time.Time { return blockTime.Add(-time.Hour) }
+	early.xnode.addData("ETH/USD", dataTimestamp, "2000")
+	early.xnode.addDeposit(testDepositHash, testDeposit)
+
+	late := newTestApplication(t, validatorKeys)
+	late.now = func() time.Time { return blockTime.Add(time.Hour) }
+	late.xnode.addData("ETH/USD", dataTimestamp, "1999")
+
+	// The nodes disagree about the data when it enters their mempools
+	attestedTx := testTransaction(t, &txcodec.ValidateDataTx{DataFeed: "ETH/USD", DataValue: "2000", DataTimestamp: dataTimestamp})
+	if check, _ := early.CheckTx(ctx, &types.RequestCheckTx{Tx: attestedTx}); check.Code != CodeTypeDataTooNew {
+		t.Fatalf("early clock accepted data from the future: code %d", check.Code)
+	}
+	if check, _ := late.CheckTx(ctx, &types.RequestCheckTx{Tx: attestedTx}); check.Code != CodeTypeDataNotVerified {
+		t.Fatalf("late node accepted data its xnode did not observe: code %d", check.Code)
+	}
+
+	commit := testExtendedCommit(t, validatorKeys, 2, VoteExtension{
+		Data:     []DataObservation{{DataFeed: "ETH/USD", DataValue: "2000", DataTimestamp: dataTimestamp}},
+		Deposits: []DepositObservation{{TransactionHash: testDepositHash, DepositItem: testDeposit}},
+	})
+	attestTx, err := attestDataTransaction(commit)
+	if err != nil {
+		t.Fatalf("creating attestation transaction: %v", err)
+	}
+	tooNewTx := testTransaction(t, &txcodec.ValidateDataTx{DataFeed: "BTC/USD", DataValue: "1", DataTimestamp: uint64(blockTime.Unix())})
+	txs := [][]byte{attestTx, attestedTx, tooNewTx}
+
+	lastCommit := extendedCommitInfoToCommitInfo(commit)
+	responses := make([]*types.ResponseFinalizeBlock, 0, 2)
+	for _, app := range []*Application{early, late} {
+		proposal, err := app.ProcessProposal(ctx, &types.RequestProcessProposal{Height: 2, Time: blockTime, Txs: txs[:2], ProposedLastCommit: *lastCommit})
+		if err != nil {
+			t.Fatalf("processing proposal: %v", err)
+		}
+		if proposal.Status != types.ResponseProcessProposal_ACCEPT {
+			t.Fatalf("valid proposal rejected")
+		}
+
+		response, err := app.FinalizeBlock(ctx, &types.RequestFinalizeBlock{Height: 2, Time: blockTime, Txs: txs, DecidedLastCommit: *lastCommit})
+		if err != nil {
+			t.Fatalf("finalizing block: %v", err)
+		}
+		responses = append(responses, response)
+	}
+
+	expectedCodes := []uint32{CodeTypeOK, CodeTypeOK, CodeTypeDataTooNew}
+	for i, result := range responses[0].TxResults {
+		if result.Code != expectedCodes[i] {
+			t.Errorf("transaction %d: expected code %d, got %d (%v)", i, expectedCodes[i], result.Code, result.Log)
+		}
+	}
+
+	earlyResponse, err := responses[0].Marshal()
+	if err != nil {
+		t.Fatalf("encoding response: %v", err)
+	}
+	lateResponse, err := responses[1].Marshal()
+	if err != nil {
+		t.Fatalf("encoding response: %v", err)
+	}
+	if !bytes.Equal(earlyResponse, lateResponse) {
+		t.Fatalf("nodes executed the block differently:\n%v\n%v", responses[0], responses[1])
+	}
+
+	if early.VerifiedData["ETH/USD"].Data != "2000" || late.VerifiedData["ETH/USD"].Data != "2000" {
+		t.Errorf("attested data not verified: %v, %v", early.VerifiedData, late.VerifiedData)
+	}
+	if deposit, attested := late.Deposits[testDepositHash]; !attested || deposit.Claimed || deposit.AttestedHeight != 2 {
+		t.Errorf("attested deposit missing on node whose xnode did not observe it: %+v", deposit)
+	}
+}
+
+// The incrementally hashed state has to match a hash of the whole state, and simulating a proposal may not change it
+func TestIncrementalStateHash(t *testing.T) {
+	ctx := context.Background()
+	validatorKeys := []ed25519.PrivKey{ed25519.GenPrivKey()}
+	validatorAddress := validatorKeys[0].PubKey().Address().String()
+	app := newTestApplication(t, validatorKeys)
+	blockTime := time.Unix(1_700_000_000, 0)
+	dataTimestamp := uint64(blockTime.Unix()) - 10
+
+	key := ed25519.GenPrivKey()
+	address := key.PubKey().Address().String()
+	app.Accounts[address] = Account{Tokens: u256.New(1_000), AccountNumber: app.newAccountNumber()}
+	app.markDirty(accountPrefix + address)
+	recipientAddress := ed25519.GenPrivKey().PubKey().Address().String()
+
+	commit := testExtendedCommit(t, validatorKeys, 2, VoteExtension{
+		Data:     []DataObservation{{DataFeed: "ETH/USD", DataValue: "2000", DataTimestamp: dataTimestamp}, {DataFeed: "BTC/USD", DataValue: "1", DataTimestamp: dataTimestamp}},
+		Deposits: []DepositObservation{{TransactionHash: testDepositHash, DepositItem: testDeposit}},
+	})
+	attestTx, err := attestDataTransaction(commit)
+	if err != nil {
+		t.Fatalf("creating attestation transaction: %v", err)
+	}
+	lastCommit := extendedCommitInfoToCommitInfo(commit)
+
+	execute := func(height int64, txs [][]byte) {
+		t.Helper()
+		request := &types.RequestFinalizeBlock{Height: height, Time: blockTime.Add(time.Duration(height) * time.Second), Txs: txs, DecidedLastCommit: *lastCommit}
+		if height > 2 {
+			entries := testStateEntries(t, app)
+			proposal, err := app.ProcessProposal(ctx, &types.RequestProcessProposal{Height: height, Time: request.Time, Txs: txs, ProposedLastCommit: *lastCommit})
+			if err != nil || proposal.Status != types.ResponseProcessProposal_ACCEPT {
+				t.Fatalf("processing proposal at height %d: %v", height, err)
+			}
+			if !maps.EqualFunc(entries, testStateEntries(t, app), bytes.Equal) {
+				t.Fatalf("processing proposal at height %d changed the state", height)
+			}
+		}
+
+		response, err := app.FinalizeBlock(ctx, request)
+		if err != nil {
+			t.Fatalf("finalizing block %d: %v", height, err)
+		}
+		for i, result := range response.TxResults {
+			if result.Code != CodeTypeOK {
+				t.Fatalf("transaction %d at height %d: code %d (%v)", i, height, result.Code, result.Log)
+			}
+		}
+		entries := testStateEntries(t, app)
+		if !bytes.Equal(response.AppHash, newStateTree(entries).hash()) {
+			t.Fatalf("app hash at height %d differs from the hash of the whole state", height)
+		}
+		if _, err := app.Commit(ctx, &types.RequestCommit{}); err != nil {
+			t.Fatalf("committing block %d: %v", height, err)
+		}
+
+		loaded, err := NewApplication(app.db)
+		if err != nil {
+			t.Fatalf("loading state: %v", err)
+		}
+		if !bytes.Equal(loaded.tree.hash(), response.AppHash) || !maps.EqualFunc(loaded.committed, entries, bytes.Equal) {
+			t.Fatalf("state saved at height %d differs from the finalized state", height)
+		}
+	}
+
+	execute(2, [][]byte{
+		attestTx,
+		testTransaction(t, &txcodec.ValidateDataTx{DataFeed: "ETH/USD", DataValue: "2000", DataTimestamp: dataTimestamp}),
+		testSignedTransaction(t, app, key, &txcodec.SendTx{FromAddress: address, ToAddress: recipientAddress, Amount: u256.New(100), Auth: txcodec.Auth{Fee: u256.New(1)}}),
+	})
+	execute(3, [][]byte{
+		testSignedTransaction(t, app, key, &txcodec.DelegateTx{DelegatorAddress: address, ValidatorAddress: validatorAddress, Amount: u256.New(200)}),
+	})
+	execute(4, [][]byte{
+		testSignedTransaction(t, app, key, &txcodec.UndelegateTx{DelegatorAddress: address, ValidatorAddress: validatorAddress, Shares: u256.New(100)}),
+		testTransaction(t, &txcodec.ValidateDataTx{DataFeed: "BTC/USD", DataValue: "1", DataTimestamp: dataTimestamp}),
+	})
+}
+
+// The fee is paid to the proposer even if it has no account yet, and a nonce can not wrap around
+func TestSignedTxFeeAndNonce(t *testing.T) {
+	ctx := context.Background()
+	validatorKeys := []ed25519.PrivKey{ed25519.GenPrivKey()}
+	app := newTestApplication(t, validatorKeys)
+	proposerAddress := validatorKeys[0].PubKey().Address().String()
+	delete(app.Accounts, proposerAddress)
+
+	key := ed25519.GenPrivKey()
+	address := key.PubKey().Address().String()
+	app.Accounts[address] = Account{Tokens: u256.New(10), Rewards: u256.New(5), AccountNumber: app.newAccountNumber()}
+	withdraw := &txcodec.WithdrawRewardsTx{Address: address, Auth: txcodec.Auth{Fee: u256.New(3)}}
+	block := newBlockContext(2, time.Unix(1_700_000_000, 0), validatorKeys[0].PubKey().Address(), nil)
+	if result := app.deliverTx(testSignedTransaction(t, app, key, withdraw), block); result.Code != CodeTypeOK {
+		t.Fatalf("withdraw failed: code %d (%v)", result.Code, result.Log)
+	}
+	if proposer, exists := app.Accounts[proposerAddress]; !exists || proposer.Tokens != u256.New(3) {
+		t.Errorf("fee not paid to the proposer: %+v", proposer)
+	}
+	if signer := app.Accounts[address]; signer.Tokens != u256.New(12) || signer.Nonce != 1 {
+		t.Errorf("fee not charged to the signer: %+v", signer)
+	}
+
+	signer := app.Accounts[address]
+	signer.Nonce = math.MaxUint64
+	app.Accounts[address] = signer
+	withdraw.Auth = txcodec.Auth{}
+	if check, _ := app.CheckTx(ctx, &types.RequestCheckTx{Tx: testSignedTransaction(t, app, key, withdraw)}); check.Code != CodeTypeInvalidSingature {
+		t.Errorf("transaction with the last nonce accepted: code %d", check.Code)
+	}
+}
+
+// Staking nothing without a validator must not leave a validator without a consensus key behind
+func TestStakeWithoutValidator(t *testing.T) {
+	validatorKeys := []ed25519.PrivKey{ed25519.GenPrivKey()}
+	app := newTestApplication(t, validatorKeys)
+	block := newBlockContext(2, time.Unix(1_700_000_000, 0), validatorKeys[0].PubKey().Address(), nil)
+
+	key := ed25519.GenPrivKey()
+	address := key.PubKey().Address().String()
+	app.Accounts[address] = Account{Tokens: u256.New(10), AccountNumber: app.newAccountNumber()}
+	for _, unstake := range []bool{false, true} {
+		stake := &txcodec.StakeTokensTx{ValidatorAddress: address, Unstake: unstake}
+		if result := app.deliverTx(testSignedTransaction(t, app, key, stake), block); result.Code != CodeTypeInvalidValidator {
+			t.Errorf("stake of 0 (unstake: %v) without a validator: code %d", unstake, result.Code)
+		}
+	}
+	app.removeBelowMinimum(address, block)
+	if validator, exists := app.Validators[address]; exists {
+		t.Fatalf("validator without a key created: %+v", validator)
+	}
+
+	create := &txcodec.CreateValidatorTx{PubKey: key.PubKey().Bytes(), Moniker: "validator"}
+	if result := app.deliverTx(testSignedTransaction(t, app, key, create), block); result.Code != CodeTypeOK {
+		t.Fatalf("create validator failed: code %d (%v)", result.Code, result.Log)
+	}
+
+	// Left behind by earlier versions, must never reach CometBFT
+	app.Validators["keyless"] = AbciValidator{GovernancePower: u256.Must(minimumValidatorPower.Mul(u256.New(2)))}
+	for _, update := range app.updateActiveSet() {
+		if len(update.PubKey.GetEd25519()) != ed25519.PubKeySize {
+			t.Errorf("validator update without a consensus key: %+v", update)
+		}
+	}
+	if _, active := app.ActiveSet["keyless"]; active {
+		t.Error("validator without a consensus key in the active set")
+	}
+}
+
+// Removing a validator returns all of its power, the rounding leftovers included
+func TestRemoveBelowMinimumConservesSupply(t *testing.T) {
+	validatorKeys := []ed25519.PrivKey{ed25519.GenPrivKey()}
+	app := newTestApplication(t, validatorKeys)
+	block := newBlockContext(2, time.Unix(1_700_000_000, 0), validatorKeys[0].PubKey().Address(), nil)
+	supply := func() u256.Int {
+		total := app.CommunityPool
+		for _, account := range app.Accounts {
+			total = u256.Must(total.Add(account.Tokens))
+		}
+		for _, validator := range app.Validators {
+			total = u256.Must(total.Add(validator.GovernancePower))
+		}
+		for _, entries := range app.Unbondings {
+			for _, entry := range entries {
+				total = u256.Must(total.Add(entry.Tokens))
+			}
+		}
+		return total
+	}
+
+	key := ed25519.GenPrivKey()
+	validatorAddress := key.PubKey().Address().String()
+	app.Validators[validatorAddress] = AbciValidator{PubKey: key.PubKey().(ed25519.PubKey)}
+	for i, amount := range []uint64{7, 11, 13} {
+		delegatorAddress := fmt.Sprintf("delegator-%d", i)
+		app.Accounts[delegatorAddress] = Account{Tokens: u256.New(amount), AccountNumber: app.newAccountNumber()}
+		app.delegate(delegatorAddress, validatorAddress, u256.New(amount))
+	}
+	validator := app.Validators[validatorAddress]
+	validator.GovernancePower = u256.New(29) // Slashed, so the shares are no longer worth whole tokens
+	app.Validators[validatorAddress] = validator
+	before := supply()
+
+	app.removeBelowMinimum(validatorAddress, block)
+	if after := supply(); after != before {
+		t.Errorf("supply changed from %v to %v", before, after)
+	}
+	if validator := app.Validators[validatorAddress]; !validator.GovernancePower.IsZero() || !validator.DelegatorShares.IsZero() {
+		t.Errorf("validator not emptied: %+v", validator)
+	}
+	if !app.CommunityPool.IsZero() {
+		t.Errorf("leftovers not returned to the delegators: %v", app.CommunityPool)
+	}
+
+	// Power without any delegations has nobody to return it to
+	app.Validators[validatorAddress] = AbciValidator{PubKey: key.PubKey().(ed25519.PubKey), GovernancePower: u256.New(5)}
+	before = supply()
+	app.removeBelowMinimum(validatorAddress, block)
+	if after := supply(); after != before || app.CommunityPool != u256.New(5) {
+		t.Errorf("power without delegations lost: supply %v, community pool %v", after, app.CommunityPool)
+	}
+}
+
+// Without the CometBFT transaction index there is no transfer history, which has to be an error instead of an empty history
+func TestTransfersWithoutIndexer(t *testing.T) {
+	app := newTestApplication(t, []ed25519.PrivKey{ed25519.GenPrivKey()})
+	response, err := app.Query(context.Background(), &types.RequestQuery{Path: "transfers/" + testDepositor})
+	if err != nil || response.Code != CodeTypeInvalidQuery || !strings.Contains(response.Log, "does not index transactions") {
+		t.Errorf("transfers without an indexer: %+v (error %v)", response, err)
+	}
+}
+
+// Deposits are attested once they are deep enough and retracted when they are reorged out before they are claimed
+func TestDepositConfirmationsAndReorg(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApplication(t, []ed25519.PrivKey{ed25519.GenPrivKey()})
+	app.xnode.addDeposit(testDepositHash, testDeposit)
+
+	extend := func() *VoteExtension {
+		t.Helper()
+		response, err := app.ExtendVote(ctx, &types.RequestExtendVote{})
+		if err != nil {
+			t.Fatalf("extending vote: %v", err)
+		}
+		extension, err := decodeVoteExtension(response.VoteExtension)
+		if err != nil {
+			t.Fatalf("decoding vote extension: %v", err)
+		}
+		return extension
+	}
+
+	app.xnode.setHead(testDeposit.BlockNumber + uint64(app.Params.DepositConfirmations) - 2)
+	if extension := extend(); len(extension.Deposits) != 0 {
+		t.Fatalf("deposit without enough confirmations attested: %+v", extension.Deposits)
+	}
+	app.xnode.setHead(testDeposit.BlockNumber + uint64(app.Params.DepositConfirmations) - 1)
+	extension := extend()
+	if len(extension.Deposits) != 1 || extension.Deposits[0].DepositItem != testDeposit {
+		t.Fatalf("confirmed deposit not attested: %+v", extension.Deposits)
+	}
+	app.storeAttestations(extension, 2)
+
+	app.xnode.removeDeposit(testDepositHash, testDepositBlock)
+	extension = extend()
+	if len(extension.Deposits) != 0 || len(extension.Retractions) != 1 {
+		t.Fatalf("reorged deposit not retracted: %+v", extension)
+	}
+	app.storeAttestations(extension, 3)
+	if _, exists := app.Deposits[testDepositHash]; exists {
+		t.Errorf("retracted deposit still claimable")
+	}
+
+	// A claimed deposit can not be retracted, the tokens are already on this chain
+	app.Deposits[testDepositHash] = DepositRecord{DepositItem: testDeposit, AttestedHeight: 2, Claimed: true}
+	if extension := extend(); len(extension.Retractions) != 0 {
+		t.Errorf("claimed deposit retracted: %+v", extension.Retractions)
+	}
+}
+
+// Every validator has to accept the vote extension of an xnode observing more feeds than fit in one, and forget what was attested
+func TestVoteExtensionFeedLimit(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApplication(t, []ed25519.PrivKey{ed25519.GenPrivKey()})
+	for i := 0; i < maxVoteExtensionFeeds+10; i++ {
+		app.xnode.addData(fmt.Sprintf("feed-%03d", i), 1, "1")
+	}
+	app.xnode.addData("feed-000", 2, strings.Repeat("1", txcodec.MaxFieldSize+1))
+
+	response, err := app.ExtendVote(ctx, &types.RequestExtendVote{})
+	if err != nil {
+		t.Fatalf("extending vote: %v", err)
+	}
+	verify, err := app.VerifyVoteExtension(ctx, &types.RequestVerifyVoteExtension{VoteExtension: response.VoteExtension})
+	if err != nil || verify.Status != types.ResponseVerifyVoteExtension_ACCEPT {
+		t.Fatalf("own vote extension rejected: %v %v", verify, err)
+	}
+	extension, err := decodeVoteExtension(response.VoteExtension)
+	if err != nil {
+		t.Fatalf("decoding vote extension: %v", err)
+	}
+	if len(extension.Data) != maxVoteExtensionFeeds || extension.Data[maxVoteExtensionFeeds-1].DataFeed != fmt.Sprintf("feed-%03d", maxVoteExtensionFeeds-1) {
+		t.Fatalf("vote extension should contain the first %d feeds by name: %+v", maxVoteExtensionFeeds, extension.Data)
+	}
+
+	app.storeAttestations(extension, 2)
+	app.xnode.pruneData(app.VerifiedData, app.AttestedData)
+	if _, exists := app.xnode.getData("feed-000", 1); exists {
+		t.Error("attested observation not forgotten")
+	}
+	if _, exists := app.xnode.getData(fmt.Sprintf("feed-%03d", maxVoteExtensionFeeds), 1); !exists {
+		t.Error("observation that is not attested yet forgotten")
+	}
+}
+
+// A data feed name can not contain the separator of the history keys
+func TestValidDataFeed(t *testing.T) {
+	for dataFeed, valid := range map[string]bool{
+		"Binance|BTCUSDT|price": true,
+		"ETH/USD":               true,
+		"":                      false,
+		"ETH/USD\x00":           false,
+		"ETH\nUSD":              false,
+	} {
+		if validDataFeed(dataFeed) != valid {
+			t.Errorf("validDataFeed(%q) should be %v", dataFeed, valid)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/cometbft/cometbft/abci/types"
+	cmtbytes "github.com/cometbft/cometbft/libs/bytes"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	cmttypes "github.com/cometbft/cometbft/types"
+
+	"tendermint-app/txcodec"
+)
+
+// Xnode data attestations
+// Every validator extends its precommit with the observations of its xnode that are not yet verified
+// The next proposer injects these vote extensions as an AttestDataTx, data observed by more than 2/3 of the voting power is stored as attested
+// A ValidateDataTx can only verify data that has been attested, so the result does not depend on the xnode of the executing node
+// Deposits are attested the same way before they can be claimed, once they have Params.DepositConfirmations confirmations
+// Deposits that were attested but reorged out of Ethereum before they were claimed are retracted the same way
+const (
+	maxObservationsPerFeed   = 10  // Latest observations of a data feed to include in a vote extension
+	maxAttestedPerFeed       = 100 // Latest attested values of a data feed to keep in the state
+	maxVoteExtensionFeeds    = 100 // Data feeds a single vote extension can contain observations for
+	maxVoteExtensionDeposits = 100 // Deposits (and retractions of deposits) a single vote extension can contain
+)
+
+type DataObservation struct {
+	DataFeed      string
+	DataValue     string
+	DataTimestamp uint64
+}
+
+type DepositObservation struct {
+	TransactionHash string
+	DepositItem
+}
+
+// Deposit that was removed from the block it was attested in
+type DepositRetraction struct {
+	TransactionHash string
+	BlockHash       string
+}
+
+type VoteExtension struct {
+	Data        []DataObservation
+	Deposits    []DepositObservation
+	Retractions []DepositRetraction
+}
+
+// Our xnode observations that are newer than the verified data and not yet attested, confirmed deposits that are not yet attested or claimed
+// and attested deposits that are not claimed but were reorged out
+func (app *Application) ExtendVote(_ context.Context, req *types.RequestExtendVote) (*types.ResponseExtendVote, error) {
+	extension := VoteExtension{Data: make([]DataObservation, 0), Deposits: make([]DepositObservation, 0), Retractions: make([]DepositRetraction, 0)}
+	data := app.xnode.allData()
+	dataFeeds := make([]string, 0, len(data))
+	for dataFeed := range data {
+		dataFeeds = append(dataFeeds, dataFeed)
+	}
+	sort.Strings(dataFeeds) // Feeds past maxVoteExtensionFeeds are left out by name, so every node leaves out the same feeds
+	feeds := 0
+	for _, dataFeed := range dataFeeds {
+		if feeds == maxVoteExtensionFeeds {
+			break
+		}
+		observations := data[dataFeed]
+		timestamps := make([]uint64, 0, len(observations))
+		for timestamp, value := range observations {
+			if timestamp <= app.VerifiedData[dataFeed].Timestamp {
+				continue // Can never become verified anymore
+			}
+			if len(value) > txcodec.MaxFieldSize {
+				continue // Does not fit in a ValidateDataTx
+			}
+			if attestedValue, attested := app.AttestedData[dataFeed][timestamp]; attested && attestedValue == value {
+				continue
+			}
+			timestamps = append(timestamps, timestamp)
+		}
+		if len(timestamps) == 0 {
+			continue
+		}
+		feeds++
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] > timestamps[j] })
+
+		for i := 0; i < len(timestamps) && i < maxObservationsPerFeed; i++ {
+			extension.Data = append(extension.Data, DataObservation{DataFeed: dataFeed, DataValue: observations[timestamps[i]], DataTimestamp: timestamps[i]})
+		}
+	}
+	sort.Slice(extension.Data, func(i, j int) bool {
+		if extension.Data[i].DataFeed != extension.Data[j].DataFeed {
+			return extension.Data[i].DataFeed < extension.Data[j].DataFeed
+		}
+		return extension.Data[i].DataTimestamp < extension.Data[j].DataTimestamp
+	})
+
+	for transactionHash, deposit := range app.xnode.confirmedDeposits(app.Params.DepositConfirmations) {
+		if record, attested := app.Deposits[transactionHash]; attested && (record.Claimed || record.DepositItem == deposit) {
+			continue
+		}
+		extension.Deposits = append(extension.Deposits, DepositObservation{TransactionHash: transactionHash, DepositItem: deposit})
+	}
+	sort.Slice(extension.Deposits, func(i, j int) bool {
+		return extension.Deposits[i].TransactionHash < extension.Deposits[j].TransactionHash
+	})
+	if len(extension.Deposits) > maxVoteExtensionDeposits {
+		extension.Deposits = extension.Deposits[:maxVoteExtensionDeposits] // The rest follows once these are attested
+	}
+
+	for transactionHash, blockHash := range app.xnode.removedDeposits() {
+		if record, attested := app.Deposits[transactionHash]; !attested || record.Claimed || record.BlockHash != blockHash {
+			continue
+		}
+		extension.Retractions = append(extension.Retractions, DepositRetraction{TransactionHash: transactionHash, BlockHash: blockHash})
+	}
+	sort.Slice(extension.Retractions, func(i, j int) bool {
+		return extension.Retractions[i].TransactionHash < extension.Retractions[j].TransactionHash
+	})
+	if len(extension.Retractions) > maxVoteExtensionDeposits {
+		extension.Retractions = extension.Retractions[:maxVoteExtensionDeposits]
+	}
+
+	voteExtension, err := json.Marshal(extension)
+	if err != nil {
+		return nil, err
+	}
+	return &types.ResponseExtendVote{VoteExtension: voteExtension}, nil
+}
+
+func (app *Application) VerifyVoteExtension(_ context.Context, req *types.RequestVerifyVoteExtension) (*types.ResponseVerifyVoteExtension, error) {
+	if _, err := decodeVoteExtension(req.VoteExtension); err != nil {
+		return &types.ResponseVerifyVoteExtension{Status: types.ResponseVerifyVoteExtension_REJECT}, nil
+	}
+	return &types.ResponseVerifyVoteExtension{Status: types.ResponseVerifyVoteExtension_ACCEPT}, nil
+}
+
+func decodeVoteExtension(voteExtension []byte) (*VoteExtension, error) {
+	extension := &VoteExtension{}
+	if len(voteExtension) == 0 {
+		return extension, nil // Validators without xnode data, or not voting for the block
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(voteExtension))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(extension); err != nil {
+		return nil, err
+	}
+
+	observationsPerFeed := make(map[string]int)
+	for _, observation := range extension.Data {
+		if !validDataFeed(observation.DataFeed) {
+			return nil, fmt.Errorf("invalid data feed %q", observation.DataFeed)
+		}
+		if len(observation.DataValue) > txcodec.MaxFieldSize {
+			return nil, fmt.Errorf("data of %v larger than %d bytes", observation.DataFeed, txcodec.MaxFieldSize)
+		}
+		observationsPerFeed[observation.DataFeed]++
+		if observationsPerFeed[observation.DataFeed] > maxObservationsPerFeed {
+			return nil, fmt.Errorf("more than %d observations of %v", maxObservationsPerFeed, observation.DataFeed)
+		}
+	}
+	if len(observationsPerFeed) > maxVoteExtensionFeeds {
+		return nil, fmt.Errorf("observations of more than %d data feeds", maxVoteExtensionFeeds)
+	}
+
+	if len(extension.Deposits) > maxVoteExtensionDeposits {
+		return nil, fmt.Errorf("more than %d deposits", maxVoteExtensionDeposits)
+	}
+	deposits := make(map[string]bool, len(extension.Deposits))
+	for _, deposit := range extension.Deposits {
+		if deposits[deposit.TransactionHash] {
+			return nil, fmt.Errorf("multiple observations of deposit %v", deposit.TransactionHash)
+		}
+		deposits[deposit.TransactionHash] = true
+		if !validTransactionHash(deposit.TransactionHash) || !isEthereumAddress(deposit.Address) || !validAccountAddress(deposit.Address) || deposit.Amount.IsZero() ||
+			deposit.BlockNumber == 0 || !validTransactionHash(deposit.BlockHash) {
+			return nil, fmt.Errorf("invalid deposit %v (%v from %v in block %d %v)", deposit.TransactionHash, deposit.Amount, deposit.Address, deposit.BlockNumber, deposit.BlockHash)
+		}
+	}
+
+	if len(extension.Retractions) > maxVoteExtensionDeposits {
+		return nil, fmt.Errorf("more than %d retractions", maxVoteExtensionDeposits)
+	}
+	retractions := make(map[string]bool, len(extension.Retractions))
+	for _, retraction := range extension.Retractions {
+		if retractions[retraction.TransactionHash] {
+			return nil, fmt.Errorf("multiple retractions of deposit %v", retraction.TransactionHash)
+		}
+		retractions[retraction.TransactionHash] = true
+		if !validTransactionHash(retraction.TransactionHash) || !validTransactionHash(retraction.BlockHash) {
+			return nil, fmt.Errorf("invalid retraction of deposit %v in block %v", retraction.TransactionHash, retraction.BlockHash)
+		}
+	}
+	return extension, nil
+}
+
+// Data, deposits and retractions observed by validators with more than 2/3 of the voting power of the previous block
+// The vote extensions in commit are verified against the commit info CometBFT provided for this block (lastCommit)
+func (app *Application) aggregateAttestations(height int64, commit *types.ExtendedCommitInfo, lastCommit *types.CommitInfo) (*VoteExtension, error) {
+	if len(commit.Votes) != len(lastCommit.Votes) || commit.Round != lastCommit.Round {
+		return nil, errors.New("attested commit does not match the last commit")
+	}
+
+	totalPower := int64(0)
+	attestingPower := make(map[DataObservation]int64)
+	depositAttestingPower := make(map[DepositObservation]int64)
+	retractionAttestingPower := make(map[DepositRetraction]int64)
+	for i, vote := range commit.Votes {
+		lastVote := lastCommit.Votes[i]
+		if !bytes.Equal(vote.Validator.Address, lastVote.Validator.Address) || vote.Validator.Power != lastVote.Validator.Power || vote.BlockIdFlag != lastVote.BlockIdFlag {
+			return nil, fmt.Errorf("attested vote %d does not match the last commit", i)
+		}
+		totalPower += vote.Validator.Power
+
+		if vote.BlockIdFlag != cmtproto.BlockIDFlagCommit {
+			if len(vote.VoteExtension) > 0 || len(vote.ExtensionSignature) > 0 {
+				return nil, fmt.Errorf("vote extension of %v did not vote for the block", cmtbytes.HexBytes(vote.Validator.Address))
+			}
+			continue
+		}
+
+		validator := app.Validators[cmtbytes.HexBytes(vote.Validator.Address).String()]
+		if len(validator.PubKey) == 0 {
+			return nil, fmt.Errorf("unknown validator %v", cmtbytes.HexBytes(vote.Validator.Address))
+		}
+		signBytes := cmttypes.VoteExtensionSignBytes(app.ChainID, &cmtproto.Vote{
+			Height:    height - 1,
+			Round:     commit.Round,
+			Extension: vote.VoteExtension,
+		})
+		if !validator.PubKey.VerifySignature(signBytes, vote.ExtensionSignature) {
+			return nil, fmt.Errorf("invalid vote extension signature of %v", cmtbytes.HexBytes(vote.Validator.Address))
+		}
+
+		extension, err := decodeVoteExtension(vote.VoteExtension)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vote extension of %v: %w", cmtbytes.HexBytes(vote.Validator.Address), err)
+		}
+		observed := make(map[DataObservation]bool)
+		for _, observation := range extension.Data {
+			if !observed[observation] {
+				observed[observation] = true
+				attestingPower[observation] += vote.Validator.Power
+			}
+		}
+		for _, deposit := range extension.Deposits {
+			depositAttestingPower[deposit] += vote.Validator.Power // Transaction hashes are unique within an extension
+		}
+		for _, retraction := range extension.Retractions {
+			retractionAttestingPower[retraction] += vote.Validator.Power
+		}
+	}
+
+	attested := &VoteExtension{Data: make([]DataObservation, 0), Deposits: make([]DepositObservation, 0), Retractions: make([]DepositRetraction, 0)}
+	for observation, power := range attestingPower {
+		if power*3 > totalPower*2 {
+			attested.Data = append(attested.Data, observation)
+		}
+	}
+	sort.Slice(attested.Data, func(i, j int) bool {
+		if attested.Data[i].DataFeed != attested.Data[j].DataFeed {
+			return attested.Data[i].DataFeed < attested.Data[j].DataFeed
+		}
+		return attested.Data[i].DataTimestamp < attested.Data[j].DataTimestamp
+	})
+	for deposit, power := range depositAttestingPower {
+		if power*3 > totalPower*2 {
+			attested.Deposits = append(attested.Deposits, deposit)
+		}
+	}
+	sort.Slice(attested.Deposits, func(i, j int) bool {
+		return attested.Deposits[i].TransactionHash < attested.Deposits[j].TransactionHash
+	})
+	for retraction, power := range retractionAttestingPower {
+		if power*3 > totalPower*2 {
+			attested.Retractions = append(attested.Retractions, retraction)
+		}
+	}
+	sort.Slice(attested.Retractions, func(i, j int) bool {
+		return attested.Retractions[i].TransactionHash < attested.Retractions[j].TransactionHash
+	})
+	return attested, nil
+}
+
+// Stores attested data and deposits, so they can be verified with a ValidateDataTx or claimed with a ClaimTokensTx
+// Retracted deposits are removed first, a deposit that was mined again in another block can be attested in the same block
+func (app *Application) storeAttestations(attested *VoteExtension, height int64) {
+	for _, retraction := range attested.Retractions {
+		if record, exists := app.Deposits[retraction.TransactionHash]; exists && !record.Claimed && record.BlockHash == retraction.BlockHash {
+			delete(app.Deposits, retraction.TransactionHash)
+			app.markDirty(depositPrefix + retraction.TransactionHash)
+		}
+	}
+	for _, deposit := range attested.Deposits {
+		if app.Deposits[deposit.TransactionHash].Claimed {
+			continue
+		}
+		app.Deposits[deposit.TransactionHash] = DepositRecord{
+			DepositItem:    deposit.DepositItem,
+			AttestedHeight: height,
+		}
+		app.markDirty(depositPrefix + deposit.TransactionHash)
+	}
+	for _, observation := range attested.Data {
+		if observation.DataTimestamp <= app.VerifiedData[observation.DataFeed].Timestamp {
+			continue
+		}
+		if _, exists := app.AttestedData[observation.DataFeed]; !exists {
+			app.AttestedData[observation.DataFeed] = make(map[uint64]string)
+		}
+		app.AttestedData[observation.DataFeed][observation.DataTimestamp] = observation.DataValue
+		app.markDirty(attestedPrefix + observation.DataFeed)
+	}
+}
+
+// Removes attested data that is older than the verified data and limits the amount of attested values per data feed
+func (app *Application) pruneAttestations() {
+	for dataFeed, attested := range app.AttestedData {
+		count := len(attested)
+		timestamps := make([]uint64, 0, len(attested))
+		for timestamp := range attested {
+			if timestamp <= app.VerifiedData[dataFeed].Timestamp {
+				delete(attested, timestamp)
+				continue
+			}
+			timestamps = append(timestamps, timestamp)
+		}
+		sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] > timestamps[j] })
+		for i := maxAttestedPerFeed; i < len(timestamps); i++ {
+			delete(attested, timestamps[i])
+		}
+
+		if len(attested) != count {
+			app.markDirty(attestedPrefix + dataFeed)
+		}
+		if len(attested) == 0 {
+			delete(app.AttestedData, dataFeed)
+		}
+	}
+}
+
+// Transaction containing the vote extensions of the previous block, to be added by the proposer
+func attestDataTransaction(commit types.ExtendedCommitInfo) ([]byte, error) {
+	return txcodec.Encode(&txcodec.AttestDataTx{Commit: commit})
+}
+
+// Whether any validator included a vote extension in the commit
+func hasVoteExtensions(commit types.ExtendedCommitInfo) bool {
+	for _, vote := range commit.Votes {
+		if len(vote.ExtensionSignature) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/cometbft/cometbft/abci/types"
+
+	"tendermint-app/txcodec"
+	"tendermint-app/u256"
+)
+
+// Signed transactions
+// Every transaction of an account is signed over a txcodec.SignDoc with the chain ID, the account number and the nonce of the account
+// The fee is paid to the block proposer, whose account is created if it does not exist yet
+// The same verification is used for mempool admission and during execution, so they can not disagree on what a valid signature is
+
+// Checks the signature and fee of a signed transaction, returns nil if the transaction is authorized
+func (app *Application) verifySignedTx(tx txcodec.SignedTx) (*types.ResponseCheckTx, error) {
+	signer, exists := app.Accounts[tx.Signer()]
+	if !exists {
+		return &types.ResponseCheckTx{
+			Code: CodeTypeInvalidSingature,
+			Log:  fmt.Sprintf("Signer %v has no account", tx.Signer()),
+		}, errors.New("signer has no account")
+	}
+
+	if signer.Nonce == math.MaxUint64 {
+		return &types.ResponseCheckTx{
+			Code: CodeTypeInvalidSingature,
+			Log:  fmt.Sprintf("Account %v used all its nonces", tx.Signer()),
+		}, errors.New("nonce exhausted") // Wrapping around would make every earlier transaction valid again
+	}
+
+	auth := tx.Authentication()
+	if auth.Fee.Gt(signer.Tokens) {
+		return &types.ResponseCheckTx{
+			Code: CodeTypeNotEnoughUnstakedTokens,
+			Log:  fmt.Sprintf("Trying to pay a higher fee than unstaked (attempted: %v, unstaked: %v)", auth.Fee, signer.Tokens),
+		}, errors.New("trying to pay a higher fee than unstaked")
+	}
+
+	signDoc, err := txcodec.SignDocOf(app.ChainID, signer.AccountNumber, signer.Nonce, tx)
+	if err != nil {
+		return &types.ResponseCheckTx{
+			Code: CodeTypeInvalidSingature,
+			Log:  fmt.Sprint("Error creating sign doc for signature validation", "err", err),
+		}, err
+	}
+	if err := verifySignature(tx, signer, signDoc.SignBytes()); err != nil {
+		return &types.ResponseCheckTx{
+			Code: CodeTypeInvalidSingature,
+			Log:  fmt.Sprintf("Signature of %v is not valid: %v", tx.Signer(), err),
+		}, err
+	}
+	return nil, nil
+}
+
+// Uses the nonce of the signer and pays the fee to the block proposer
+func (app *Application) chargeSignedTx(tx txcodec.SignedTx, block *blockContext) {
+	signer := app.Accounts[tx.Signer()]
+	fee := tx.Authentication().Fee
+	signer.Tokens = u256.Must(signer.Tokens.Sub(fee)) // Checked by verifySignedTx
+	signer.Nonce++                                    // Checked by verifySignedTx
+	if len(signer.PubKey) == 0 && !isEthereumAddress(tx.Signer()) {
+		signer.PubKey = tx.Authentication().PubKey // Checked against the signer address by verifySignedTx
+	}
+	app.Accounts[tx.Signer()] = signer
+	app.markDirty(accountPrefix + tx.Signer())
+
+	if fee.IsZero() {
+		return
+	}
+	proposer, exists := app.Accounts[block.ProposerAddress]
+	if !exists {
+		proposer.AccountNumber = app.newAccountNumber()
+	}
+	proposer.Tokens = u256.Must(proposer.Tokens.Add(fee)) // Checked by checkTx
+	app.Accounts[block.ProposerAddress] = proposer
+	app.markDirty(accountPrefix + block.ProposerAddress)
+}
+
+// Unstaked tokens of the signer that are left after paying the fee, verifySignedTx checked that the fee can be paid
+func spendableTokens(tx txcodec.SignedTx, signer Account) u256.Int {
+	return u256.Must(signer.Tokens.Sub(tx.Authentication().Fee))
+}
+
+// Next free account number, account numbers are never reused
+func (app *Application) newAccountNumber() uint64 {
+	accountNumber := app.NextAccountNumber
+	app.NextAccountNumber++
+	return accountNumber
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	eth "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+// Claims
+// A deposit on Ethereum is claimed with an EIP-712 signature of the depositing address over
+// Claim(string chainId,bytes32 transactionHash,string receiver) in the xnode-app version 1 domain
+// The signature is only valid for one deposit, one receiving account and one chain, so it can not be replayed elsewhere
+// See smart-contracts/scripts/signClaim.ts for a signer
+const (
+	claimDomainName    = "xnode-app"
+	claimDomainVersion = "1"
+)
+
+var claimTypes = apitypes.Types{
+	"EIP712Domain": {
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+	},
+	"Claim": {
+		{Name: "chainId", Type: "string"},
+		{Name: "transactionHash", Type: "bytes32"},
+		{Name: "receiver", Type: "string"},
+	},
+}
+
+// Reports whether the transaction hash is the canonical spelling of a 32 byte hash: 0x followed by 64 lower case hex characters
+// Deposits are stored by transaction hash, so every deposit has one spelling
+func validTransactionHash(transactionHash string) bool {
+	return len(transactionHash) == 2*common.HashLength+2 && common.HexToHash(transactionHash).Hex() == transactionHash
+}
+
+// EIP-712 hash the depositor signs to claim a deposit to the receiver
+func claimHash(chainID string, transactionHash string, receiver string) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(apitypes.TypedData{
+		Types:       claimTypes,
+		PrimaryType: "Claim",
+		Domain:      apitypes.TypedDataDomain{Name: claimDomainName, Version: claimDomainVersion},
+		Message: apitypes.TypedDataMessage{
+			"chainId":         chainID,
+			"transactionHash": transactionHash,
+			"receiver":        receiver,
+		},
+	})
+	return hash, err
+}
+
+// Recovers the Ethereum address that signed the claim (65 byte signature, recovery id 0, 1, 27 or 28)
+func claimSigner(chainID string, transactionHash string, receiver string, proof []byte) (common.Address, error) {
+	if len(proof) != 65 {
+		return common.Address{}, errors.New("proof should be a 65 byte signature")
+	}
+	hash, err := claimHash(chainID, transactionHash, receiver)
+	if err != nil {
+		return common.Address{}, err
+	}
+	signature := append([]byte{}, proof...)
+	if signature[64] >= 27 {
+		signature[64] -= 27 // Wallets use 27 and 28 as recovery id
+	}
+	pubKey, err := eth.SigToPub(hash, signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return eth.PubkeyToAddress(*pubKey), nil
+}
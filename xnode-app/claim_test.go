@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Test vectors in the format of smart-contracts/scripts/claimVectors.ts, run it to regenerate them
+type claimVector struct {
+	ChainID         string `json:"chainId"`
+	TransactionHash string `json:"transactionHash"`
+	Receiver        string `json:"receiver"`
+	Depositor       string `json:"depositor"`
+	Digest          string `json:"digest"`
+	Signature       string `json:"signature"`
+}
+
+func loadClaimVectors(t *testing.T) []claimVector {
+	t.Helper()
+	encoded, err := os.ReadFile("testdata/claim_vectors.json")
+	if err != nil {
+		t.Fatalf("reading claim vectors: %v", err)
+	}
+	vectors := []claimVector{}
+	if err := json.Unmarshal(encoded, &vectors); err != nil {
+		t.Fatalf("decoding claim vectors: %v", err)
+	}
+	return vectors
+}
+
+// Signatures of the smart contract scripts recover to the depositor, and only for the signed deposit, receiver and chain
+func TestClaimSignerVectors(t *testing.T) {
+	for _, vector := range loadClaimVectors(t) {
+		digest, err := claimHash(vector.ChainID, vector.TransactionHash, vector.Receiver)
+		if err != nil {
+			t.Fatalf("hashing claim of %v: %v", vector.TransactionHash, err)
+		}
+		if !bytes.Equal(digest, hexutil.MustDecode(vector.Digest)) {
+			t.Errorf("claim hash of %v to %v is %x, expected %v", vector.TransactionHash, vector.Receiver, digest, vector.Digest)
+		}
+
+		proof := hexutil.MustDecode(vector.Signature)
+		signer, err := claimSigner(vector.ChainID, vector.TransactionHash, vector.Receiver, proof)
+		if err != nil || signer != common.HexToAddress(vector.Depositor) {
+			t.Errorf("claim of %v to %v recovered %v (error %v), expected %v", vector.TransactionHash, vector.Receiver, signer.Hex(), err, vector.Depositor)
+		}
+
+		tampered := map[string][3]string{
+			"chain":       {vector.ChainID + "-2", vector.TransactionHash, vector.Receiver},
+			"transaction": {vector.ChainID, common.HexToHash("0x01").Hex(), vector.Receiver},
+			"receiver":    {vector.ChainID, vector.TransactionHash, "0x0000000000000000000000000000000000000001"},
+		}
+		for name, claim := range tampered {
+			signer, err := claimSigner(claim[0], claim[1], claim[2], proof)
+			if err == nil && signer == common.HexToAddress(vector.Depositor) {
+				t.Errorf("claim of %v with a different %v still recovers the depositor", vector.TransactionHash, name)
+			}
+		}
+	}
+}
+
+func TestValidTransactionHash(t *testing.T) {
+	for hash, valid := range map[string]bool{
+		"0x3d1b6a8e4b7f0c2e9a1d5f6c8b0e2a4d6f8c1e3a5b7d9f0e2c4a6b8d0f1e3a5c": true,
+		"0x3D1B6A8E4B7F0C2E9A1D5F6C8B0E2A4D6F8C1E3A5B7D9F0E2C4A6B8D0F1E3A5C": false,
+		"3d1b6a8e4b7f0c2e9a1d5f6c8b0e2a4d6f8c1e3a5b7d9f0e2c4a6b8d0f1e3a5c":   false,
+		"0x3d1b": false,
+		"":       false,
+	} {
+		if validTransactionHash(hash) != valid {
+			t.Errorf("validTransactionHash(%q) should be %v", hash, valid)
+		}
+	}
+}
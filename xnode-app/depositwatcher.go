@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	eth "github.com/ethereum/go-ethereum/crypto"
+
+	"tendermint-app/u256"
+)
+
+// Ethereum deposits
+// Instead of receiving deposits from the xnode websocket, a node can watch the deposit contracts itself over Ethereum JSON-RPC
+// Every poll filters the logs of the new blocks and rescans the latest depositRescanBlocks blocks, deposits that disappeared from
+// the rescanned blocks were reorged out. Deposits and the head are added to the xnode observations, so they are attested the same way
+// A deposit is a TokensStaked log of OpenStaking or a ValidatorPass mint, which is worth the minimum validator stake
+// Deposits are identified by their transaction hash, if a transaction contains multiple deposit logs the first one counts
+const (
+	depositRescanBlocks = 64   // Blocks behind the head in which reorgs are detected
+	depositMaxLogRange  = 5000 // Blocks per log filter request, most RPC providers limit the range
+)
+
+var (
+	tokensStakedTopic = eth.Keccak256Hash([]byte("TokensStaked(address,uint256)"))
+	transferTopic     = eth.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+	validatorPassDeposit = minimumValidatorPower // 10,000 tokens per minted pass
+)
+
+// Part of an Ethereum client the deposit watcher needs, implemented by ethclient.Client and the simulated backend
+type depositBackend interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*ethtypes.Header, error)
+	FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]ethtypes.Log, error)
+}
+
+type depositWatcher struct {
+	client        depositBackend
+	xnode         *xnodeObservations
+	openStaking   common.Address
+	validatorPass common.Address
+
+	startBlock uint64                 // First block to watch, 0 to start at the latest blocks
+	nextBlock  uint64                 // First block that has not been scanned yet
+	recent     map[string]DepositItem // Transaction hash -> deposit in the rescanned blocks, to detect deposits that were reorged out
+}
+
+func newDepositWatcher(client depositBackend, xnode *xnodeObservations, openStaking common.Address, validatorPass common.Address, startBlock uint64) *depositWatcher {
+	return &depositWatcher{
+		client:        client,
+		xnode:         xnode,
+		openStaking:   openStaking,
+		validatorPass: validatorPass,
+		startBlock:    startBlock,
+		nextBlock:     startBlock,
+		recent:        make(map[string]DepositItem),
+	}
+}
+
+// Polls for deposits until the context is cancelled, errors (like an unavailable RPC) are retried at the next poll
+func (watcher *depositWatcher) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := watcher.poll(ctx); err != nil {
+			log.Printf("Ethereum deposit watcher error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (watcher *depositWatcher) poll(ctx context.Context) error {
+	header, err := watcher.client.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("getting head: %w", err)
+	}
+	head := header.Number.Uint64()
+
+	rescanFrom := uint64(1)
+	if head > depositRescanBlocks {
+		rescanFrom = head - depositRescanBlocks + 1
+	}
+	if watcher.nextBlock == 0 {
+		watcher.nextBlock = rescanFrom
+	}
+	from := min(watcher.nextBlock, max(rescanFrom, watcher.startBlock))
+
+	found := make(map[string]DepositItem)
+	for start := from; start <= head; start += depositMaxLogRange {
+		end := min(start+depositMaxLogRange-1, head)
+		if err := watcher.filterDeposits(ctx, start, end, found); err != nil {
+			return err
+		}
+	}
+
+	for transactionHash, deposit := range watcher.recent {
+		if deposit.BlockNumber < from {
+			continue
+		}
+		if current, exists := found[transactionHash]; !exists || current.BlockHash != deposit.BlockHash {
+			watcher.xnode.removeDeposit(transactionHash, deposit.BlockHash)
+			delete(watcher.recent, transactionHash)
+			log.Printf("Deposit %v removed by a reorg of block %v", transactionHash, deposit.BlockHash)
+		}
+	}
+	for transactionHash, deposit := range found {
+		if watcher.recent[transactionHash] == deposit {
+			continue
+		}
+		watcher.xnode.addDeposit(transactionHash, deposit)
+		watcher.recent[transactionHash] = deposit
+		log.Printf("Verified deposit %v added: (%v from %v in block %d)", transactionHash, deposit.Amount, deposit.Address, deposit.BlockNumber)
+	}
+	for transactionHash, deposit := range watcher.recent {
+		if deposit.BlockNumber < rescanFrom {
+			delete(watcher.recent, transactionHash) // Too deep to be reorged
+		}
+	}
+
+	// The head is only reported once its deposits are added, so no deposit is confirmed before it is observed
+	watcher.xnode.setHead(head)
+	watcher.nextBlock = head + 1
+	return nil
+}
+
+// Adds the deposits in the blocks from start to end (inclusive) to found
+func (watcher *depositWatcher) filterDeposits(ctx context.Context, start uint64, end uint64, found map[string]DepositItem) error {
+	queries := []ethereum.FilterQuery{
+		{Addresses: []common.Address{watcher.openStaking}, Topics: [][]common.Hash{{tokensStakedTopic}}},
+		{Addresses: []common.Address{watcher.validatorPass}, Topics: [][]common.Hash{{transferTopic}, {common.Hash{}}}}, // Mints
+	}
+	for _, query := range queries {
+		query.FromBlock = new(big.Int).SetUint64(start)
+		query.ToBlock = new(big.Int).SetUint64(end)
+		logs, err := watcher.client.FilterLogs(ctx, query)
+		if err != nil {
+			return fmt.Errorf("filtering logs of %v in blocks %d to %d: %w", query.Addresses[0], start, end, err)
+		}
+
+		for _, depositLog := range logs {
+			deposit, valid := watcher.parseDeposit(depositLog)
+			if !valid {
+				log.Printf("Invalid deposit log %v of %v ignored", depositLog.TxHash, depositLog.Address)
+				continue
+			}
+			transactionHash := depositLog.TxHash.Hex()
+			if existing, exists := found[transactionHash]; exists && existing.LogIndex < deposit.LogIndex {
+				continue
+			}
+			found[transactionHash] = deposit
+		}
+	}
+	return nil
+}
+
+func (watcher *depositWatcher) parseDeposit(depositLog ethtypes.Log) (DepositItem, bool) {
+	deposit := DepositItem{BlockNumber: depositLog.BlockNumber, BlockHash: depositLog.BlockHash.Hex(), LogIndex: uint64(depositLog.Index)}
+	if depositLog.Removed || depositLog.BlockNumber == 0 || len(depositLog.Topics) == 0 {
+		return deposit, false
+	}
+
+	switch {
+	case depositLog.Address == watcher.openStaking && depositLog.Topics[0] == tokensStakedTopic:
+		// TokensStaked(address indexed account, uint256 amount)
+		if len(depositLog.Topics) != 2 || len(depositLog.Data) != 32 {
+			return deposit, false
+		}
+		amount, err := u256.FromBytes(depositLog.Data)
+		if err != nil || amount.IsZero() {
+			return deposit, false
+		}
+		deposit.Address = common.BytesToAddress(depositLog.Topics[1].Bytes()).Hex()
+		deposit.Amount = amount
+	case depositLog.Address == watcher.validatorPass && depositLog.Topics[0] == transferTopic:
+		// Transfer(address indexed from, address indexed to, uint256 indexed tokenId) from the zero address
+		if len(depositLog.Topics) != 4 || depositLog.Topics[1] != (common.Hash{}) {
+			return deposit, false
+		}
+		deposit.Address = common.BytesToAddress(depositLog.Topics[2].Bytes()).Hex()
+		deposit.Amount = validatorPassDeposit
+	default:
+		return deposit, false
+	}
+	return deposit, true
+}
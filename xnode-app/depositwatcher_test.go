@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	eth "github.com/ethereum/go-ethereum/crypto"
+
+	"tendermint-app/u256"
+)
+
+var (
+	testOpenStaking   = common.HexToAddress("0x00000000000000000000000000000000000051a4")
+	testValidatorPass = common.HexToAddress("0x0000000000000000000000000000000000000ba5")
+)
+
+// Contract that emits TokensStaked(msg.sender, amount) with the amount as calldata
+func testOpenStakingCode() []byte {
+	code := []byte{0x36, 0x60, 0x00, 0x60, 0x00, 0x37, 0x33, 0x7f} // CALLDATACOPY(0, 0, CALLDATASIZE), CALLER, PUSH32
+	code = append(code, tokensStakedTopic.Bytes()...)
+	return append(code, 0x36, 0x60, 0x00, 0xa2, 0x00) // LOG2(0, CALLDATASIZE, topic, CALLER), STOP
+}
+
+// Contract that emits Transfer(0, msg.sender, tokenId) with the token id as calldata
+func testValidatorPassCode() []byte {
+	code := []byte{0x60, 0x00, 0x35, 0x33, 0x60, 0x00, 0x7f} // CALLDATALOAD(0), CALLER, PUSH1 0, PUSH32
+	code = append(code, transferTopic.Bytes()...)
+	return append(code, 0x60, 0x00, 0x60, 0x00, 0xa4, 0x00) // LOG4(0, 0, topic, 0, CALLER, tokenId), STOP
+}
+
+func TestDepositWatcher(t *testing.T) {
+	ctx := context.Background()
+	key, err := eth.GenerateKey()
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	depositor := eth.PubkeyToAddress(key.PublicKey)
+
+	backend := backends.NewSimulatedBackend(core.GenesisAlloc{
+		depositor:         {Balance: new(big.Int).Lsh(big.NewInt(1), 100)},
+		testOpenStaking:   {Code: testOpenStakingCode(), Balance: new(big.Int)},
+		testValidatorPass: {Code: testValidatorPassCode(), Balance: new(big.Int)},
+	}, 30_000_000)
+	defer backend.Close()
+	genesis, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		t.Fatalf("getting genesis: %v", err)
+	}
+
+	xnode := newXnodeObservations()
+	watcher := newDepositWatcher(backend, xnode, testOpenStaking, testValidatorPass, 1)
+
+	send := func(nonce uint64, to common.Address, data []byte) common.Hash {
+		t.Helper()
+		tx, err := ethtypes.SignNewTx(key, ethtypes.LatestSignerForChainID(big.NewInt(1337)), &ethtypes.LegacyTx{
+			Nonce:    nonce,
+			To:       &to,
+			Gas:      100_000,
+			GasPrice: big.NewInt(10_000_000_000),
+			Data:     data,
+		})
+		if err != nil {
+			t.Fatalf("signing transaction: %v", err)
+		}
+		if err := backend.SendTransaction(ctx, tx); err != nil {
+			t.Fatalf("sending transaction: %v", err)
+		}
+		return tx.Hash()
+	}
+	amount := u256.Must(u256.Parse("123456789000000000000000")) // 123,456.789 tokens
+	stake := send(0, testOpenStaking, common.LeftPadBytes(amount.Bytes(), 32))
+	mint := send(1, testValidatorPass, common.LeftPadBytes([]byte{7}, 32))
+	block := backend.Commit()
+
+	if err := watcher.poll(ctx); err != nil {
+		t.Fatalf("polling: %v", err)
+	}
+	expected := map[common.Hash]DepositItem{
+		stake: {Address: depositor.Hex(), Amount: amount, BlockNumber: 1, BlockHash: block.Hex(), LogIndex: 0},
+		mint:  {Address: depositor.Hex(), Amount: validatorPassDeposit, BlockNumber: 1, BlockHash: block.Hex(), LogIndex: 1},
+	}
+	for transactionHash, expectedDeposit := range expected {
+		if deposit, exists := xnode.getDeposit(transactionHash.Hex()); !exists || deposit != expectedDeposit {
+			t.Errorf("deposit %v: expected %+v, got %+v", transactionHash, expectedDeposit, deposit)
+		}
+	}
+	if confirmations := xnode.getConfirmations(expected[stake]); confirmations != 1 {
+		t.Errorf("expected 1 confirmation, got %d", confirmations)
+	}
+
+	// A longer chain without the deposits replaces block 1
+	if err := backend.Fork(ctx, genesis.Hash()); err != nil {
+		t.Fatalf("forking: %v", err)
+	}
+	backend.Commit()
+	backend.Commit()
+	if err := watcher.poll(ctx); err != nil {
+		t.Fatalf("polling: %v", err)
+	}
+	removed := xnode.removedDeposits()
+	for transactionHash := range expected {
+		if _, exists := xnode.getDeposit(transactionHash.Hex()); exists {
+			t.Errorf("reorged deposit %v still observed", transactionHash)
+		}
+		if removed[transactionHash.Hex()] != block.Hex() {
+			t.Errorf("reorged deposit %v not removed: %v", transactionHash, removed)
+		}
+	}
+}
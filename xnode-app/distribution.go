@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/libs/bytes"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+
+	"tendermint-app/u256"
+)
+
+// Distribution
+// Every block mints Params.InflationRate of the bonded stake per year, for the block time since the previous block
+// Params.CommunityTax goes to the community pool and Params.ProposerBonus to the block proposer
+// The rest is split between the validators that signed the last block by their voting power, the validator keeps its commission
+// and shares the remainder with its delegators by their shares
+// Rewards accrue in Account.Rewards and are moved to Tokens by a withdraw rewards transaction, they do not change voting power
+
+const millisecondsPerYear = 365 * 24 * 60 * 60 * 1000
+
+// Mints and distributes the rewards for the time since the previous block
+func (app *Application) distributeRewards(block *blockContext) []types.Event {
+	elapsed := block.Time.Sub(app.InflationTime).Milliseconds()
+	unknownGenesisTime := app.InflationTime.IsZero()
+	app.InflationTime = block.Time
+	if elapsed <= 0 || unknownGenesisTime {
+		return nil
+	}
+
+	bonded := u256.Int{}
+	for address := range app.ActiveSet {
+		bonded = u256.Must(bonded.Add(app.Validators[address].GovernancePower))
+	}
+	// Blocks that are more than a year apart would mint more than the yearly inflation, that can overflow as well
+	provision, err := basisPointsOf(bonded, app.Params.InflationRate).MulDiv(u256.New(uint64(elapsed)), u256.New(millisecondsPerYear), false)
+	if err != nil || provision.IsZero() {
+		return nil
+	}
+
+	communityPool := basisPointsOf(provision, app.Params.CommunityTax)
+	remaining := u256.Must(provision.Sub(communityPool))
+
+	if proposer, exists := app.Validators[block.ProposerAddress]; exists && !proposer.Jailed {
+		bonus := basisPointsOf(provision, app.Params.ProposerBonus)
+		remaining = u256.Must(remaining.Sub(bonus)) // Bonus and tax are at most 100% together
+		communityPool = u256.Must(communityPool.Add(u256.Must(bonus.Sub(app.rewardValidator(block.ProposerAddress, bonus)))))
+	}
+
+	signedPower := int64(0)
+	for _, vote := range block.LastCommit.Votes {
+		if vote.BlockIdFlag == cmtproto.BlockIDFlagCommit {
+			signedPower += vote.Validator.Power
+		}
+	}
+	distributed := u256.Int{}
+	for _, vote := range block.LastCommit.Votes {
+		address := bytes.HexBytes(vote.Validator.Address).String()
+		if vote.BlockIdFlag != cmtproto.BlockIDFlagCommit || app.Validators[address].Jailed {
+			continue // Rewards of validators that did not sign go to the community pool
+		}
+		reward := u256.Must(remaining.MulDiv(u256.New(uint64(vote.Validator.Power)), u256.New(uint64(signedPower)), false))
+		distributed = u256.Must(distributed.Add(app.rewardValidator(address, reward)))
+	}
+	communityPool = u256.Must(communityPool.Add(u256.Must(remaining.Sub(distributed)))) // Including rounding leftovers
+	app.CommunityPool = u256.Must(app.CommunityPool.Add(communityPool))
+
+	event := types.Event{Type: "Rewards Minted", Attributes: make([]types.EventAttribute, 2)}
+	event.Attributes[0] = types.EventAttribute{Key: "amount", Value: provision.String()}
+	event.Attributes[1] = types.EventAttribute{Key: "communitypool", Value: communityPool.String()}
+	return []types.Event{event}
+}
+
+// Splits the reward of a validator into its commission and the rewards of its delegators, returns the amount that was paid out
+func (app *Application) rewardValidator(validatorAddress string, reward u256.Int) u256.Int {
+	validator := app.Validators[validatorAddress]
+	if reward.IsZero() || validator.DelegatorShares.IsZero() {
+		return u256.Int{}
+	}
+
+	commission := basisPointsOf(reward, int64(validator.CommissionRate))
+	paid := commission
+	account := app.Accounts[validatorAddress]
+	account.Rewards = u256.Must(account.Rewards.Add(commission))
+	app.Accounts[validatorAddress] = account
+	app.markDirty(accountPrefix + validatorAddress)
+
+	// Every delegator is rounded down on its own, so the order does not matter
+	delegatorRewards := u256.Must(reward.Sub(commission))
+	for delegatorAddress, shares := range app.Delegations[validatorAddress] {
+		delegatorReward := u256.Must(delegatorRewards.MulDiv(shares, validator.DelegatorShares, false))
+		delegator := app.Accounts[delegatorAddress]
+		delegator.Rewards = u256.Must(delegator.Rewards.Add(delegatorReward))
+		app.Accounts[delegatorAddress] = delegator
+		app.markDirty(accountPrefix + delegatorAddress)
+		paid = u256.Must(paid.Add(delegatorReward))
+	}
+	return paid
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	cmttypes "github.com/cometbft/cometbft/types"
+
+	"tendermint-app/u256"
+)
+
+// Genesis
+// Chain parameters are set in the app_state of the genesis file, they can not be changed afterwards
+// Parameters that are missing from the app_state keep their default value
+//
+//	"app_state": {"Params": {"UnbondingPeriod": 1814400}}
+type GenesisState struct {
+	Params Params
+}
+
+type Params struct {
+	UnbondingPeriod int64 // Seconds that unstaked and undelegated tokens stay slashable before they are returned
+
+	SlashFractionDuplicateVote     int64 // Basis points of the stake at the infraction height, the validator is also tombstoned
+	SlashFractionLightClientAttack int64 // Basis points of the stake at the infraction height
+	JailDuration                   int64 // Seconds before a jailed validator can unjail
+
+	SignedBlocksWindow    int64 // Amount of recent blocks in which the liveness of a validator is tracked
+	MinSignedPerWindow    int64 // Basis points of the window a validator has to sign to not be jailed
+	SlashFractionDowntime int64 // Basis points of the stake, slashed when a validator is jailed for missing blocks
+
+	InflationRate int64 // Basis points of the bonded stake that is minted as rewards per year
+	ProposerBonus int64 // Basis points of the minted rewards for the block proposer
+	CommunityTax  int64 // Basis points of the minted rewards for the community pool
+
+	PowerReduction u256.Int // Staked tokens per unit of consensus power, with 18 decimals (a decimal string in JSON)
+	MaxValidators  int64    // Size of the active set
+
+	DepositConfirmations int64 // Ethereum blocks on top of a deposit, including its own, before validators attest it
+}
+
+func defaultParams() Params {
+	return Params{
+		UnbondingPeriod: 21 * 24 * 60 * 60,
+
+		SlashFractionDuplicateVote:     500,  // 5%
+		SlashFractionLightClientAttack: 1000, // 10%
+		JailDuration:                   10 * 60,
+
+		SignedBlocksWindow:    100,
+		MinSignedPerWindow:    5000, // 50%
+		SlashFractionDowntime: 1,    // 0.01%
+
+		InflationRate: 700, // 7%
+		ProposerBonus: 100, // 1%
+		CommunityTax:  200, // 2%
+
+		PowerReduction: oneToken, // 1 token
+		MaxValidators:  100,
+
+		DepositConfirmations: 12,
+	}
+}
+
+func parseGenesisState(appStateBytes []byte) (GenesisState, error) {
+	genesis := GenesisState{Params: defaultParams()}
+	appState := bytes.TrimSpace(appStateBytes)
+	if len(appState) == 0 || bytes.Equal(appState, []byte("null")) || bytes.Equal(appState, []byte(`""`)) {
+		return genesis, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(appState))
+	decoder.DisallowUnknownFields() // A misspelled parameter would silently use its default otherwise
+	if err := decoder.Decode(&genesis); err != nil {
+		return GenesisState{}, fmt.Errorf("decoding app state: %w", err)
+	}
+	if err := genesis.Params.validate(); err != nil {
+		return GenesisState{}, err
+	}
+	return genesis, nil
+}
+
+func (params Params) validate() error {
+	if params.UnbondingPeriod <= 0 {
+		return errors.New("unbonding period should be positive")
+	}
+	if params.SlashFractionDuplicateVote < 0 || params.SlashFractionDuplicateVote > basisPoints {
+		return errors.New("duplicate vote slash fraction should be between 0 and 10000 basis points")
+	}
+	if params.SlashFractionLightClientAttack < 0 || params.SlashFractionLightClientAttack > basisPoints {
+		return errors.New("light client attack slash fraction should be between 0 and 10000 basis points")
+	}
+	if params.JailDuration < 0 {
+		return errors.New("jail duration should not be negative")
+	}
+	if params.SignedBlocksWindow <= 0 {
+		return errors.New("signed blocks window should be positive")
+	}
+	if params.MinSignedPerWindow < 0 || params.MinSignedPerWindow > basisPoints {
+		return errors.New("minimum signed per window should be between 0 and 10000 basis points")
+	}
+	if params.SlashFractionDowntime < 0 || params.SlashFractionDowntime > basisPoints {
+		return errors.New("downtime slash fraction should be between 0 and 10000 basis points")
+	}
+	if params.InflationRate < 0 || params.InflationRate > basisPoints {
+		return errors.New("inflation rate should be between 0 and 10000 basis points")
+	}
+	if params.ProposerBonus < 0 || params.CommunityTax < 0 || params.ProposerBonus+params.CommunityTax > basisPoints {
+		return errors.New("proposer bonus and community tax should not be negative and together at most 10000 basis points")
+	}
+	if params.PowerReduction.IsZero() {
+		return errors.New("power reduction should be positive")
+	}
+	if _, err := u256.New(uint64(cmttypes.MaxTotalVotingPower)).Mul(params.PowerReduction); err != nil {
+		return errors.New("power reduction is too large, the stake of the maximum voting power should fit in 256 bits")
+	}
+	if params.MaxValidators <= 0 {
+		return errors.New("max validators should be positive")
+	}
+	if params.DepositConfirmations <= 0 {
+		return errors.New("deposit confirmations should be positive")
+	}
+	return nil
+}
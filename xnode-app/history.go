@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"unicode"
+
+	dbm "github.com/cometbft/cometbft-db"
+	"github.com/cometbft/cometbft/abci/types"
+)
+
+// Data feed history
+// Every committed feed value is also stored by height, outside of the consensus state (so it is not part of the app hash or snapshots)
+// Entries older than FeedHistoryDepth blocks are pruned, except for the one that is still the value at the start of the retained window
+var historyPrefix = []byte("history/") // history/<datafeed> 0x00 <height> -> FeedHistoryItem
+
+type FeedHistoryItem struct {
+	Height    int64 // Block in which the value was verified
+	Data      string
+	Timestamp uint64
+}
+
+// Reports whether the data feed name can be stored, names are non empty and have no control characters
+// The 0x00 separator of the history keys can therefore not be part of a name, so the history of one feed never mixes with another
+func validDataFeed(dataFeed string) bool {
+	for _, c := range dataFeed {
+		if unicode.IsControl(c) {
+			return false
+		}
+	}
+	return dataFeed != ""
+}
+
+func feedHistoryStart(dataFeed string) []byte {
+	key := append(append([]byte{}, historyPrefix...), dataFeed...)
+	return append(key, 0)
+}
+
+func feedHistoryKey(dataFeed string, height int64) []byte {
+	return binary.BigEndian.AppendUint64(feedHistoryStart(dataFeed), uint64(height))
+}
+
+// Adds the new value of a data feed to its history and prunes the entries that fell out of the history window
+func (app *Application) writeFeedHistory(batch dbm.Batch, dataFeed string, item VerifiedDataItem) error {
+	value, err := json.Marshal(FeedHistoryItem{Height: app.LastBlockHeight, Data: item.Data, Timestamp: item.Timestamp})
+	if err != nil {
+		return err
+	}
+	if err := batch.Set(feedHistoryKey(dataFeed, app.LastBlockHeight), value); err != nil {
+		return err
+	}
+
+	if app.FeedHistoryDepth == 0 || uint64(app.LastBlockHeight) <= app.FeedHistoryDepth {
+		return nil
+	}
+	cutoff := app.LastBlockHeight - int64(app.FeedHistoryDepth)
+
+	// The newest entry before the cutoff is still needed to answer queries at the cutoff height
+	iterator, err := app.db.ReverseIterator(feedHistoryStart(dataFeed), feedHistoryKey(dataFeed, cutoff))
+	if err != nil {
+		return err
+	}
+	if !iterator.Valid() {
+		iterator.Close()
+		return nil
+	}
+	keep := iterator.Key()
+	iterator.Close()
+
+	iterator, err = app.db.Iterator(feedHistoryStart(dataFeed), keep)
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+	for ; iterator.Valid(); iterator.Next() {
+		if err := batch.Delete(iterator.Key()); err != nil {
+			return err
+		}
+	}
+	return iterator.Error()
+}
+
+// Value of a data feed at the given height
+func (app *Application) queryFeedAtHeight(dataFeed string, height int64) *types.ResponseQuery {
+	if !validDataFeed(dataFeed) {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid data feed %q", dataFeed))
+	}
+	if app.FeedHistoryDepth != 0 && height < app.LastBlockHeight-int64(app.FeedHistoryDepth) {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("History before height %d has been pruned, got %d", app.LastBlockHeight-int64(app.FeedHistoryDepth), height))
+	}
+
+	iterator, err := app.db.ReverseIterator(feedHistoryStart(dataFeed), feedHistoryKey(dataFeed, height+1))
+	if err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Reading history of %v: %v", dataFeed, err))
+	}
+	defer iterator.Close()
+	if !iterator.Valid() {
+		return app.queryError(CodeTypeNotFound, fmt.Sprintf("%v has no value at height %d", dataFeed, height))
+	}
+
+	historyItem := FeedHistoryItem{}
+	if err := json.Unmarshal(iterator.Value(), &historyItem); err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Decoding history of %v: %v", dataFeed, err))
+	}
+	response := app.queryResult([]byte(dataFeedPrefix+dataFeed), VerifiedDataItem{Data: historyItem.Data, Timestamp: historyItem.Timestamp})
+	response.Height = height
+	return response
+}
+
+// Page of the values of a data feed, filtered on height (fromheight, toheight) and timestamp (from, to), all inclusive
+func (app *Application) queryFeedHistory(dataFeed string, params url.Values) *types.ResponseQuery {
+	if !validDataFeed(dataFeed) {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid data feed %q", dataFeed))
+	}
+	offset, limit, err := pagination(params)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid pagination: %v", err))
+	}
+
+	fromHeight, err := uintParam(params, "fromheight", 0)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid query parameters: %v", err))
+	}
+	toHeight, err := uintParam(params, "toheight", uint64(app.LastBlockHeight))
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid query parameters: %v", err))
+	}
+	fromTimestamp, err := uintParam(params, "from", 0)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid query parameters: %v", err))
+	}
+	toTimestamp, err := uintParam(params, "to", math.MaxUint64)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid query parameters: %v", err))
+	}
+	toHeight = min(toHeight, uint64(app.LastBlockHeight))
+	fromHeight = min(fromHeight, toHeight+1)
+
+	iterator, err := app.db.Iterator(feedHistoryKey(dataFeed, int64(fromHeight)), feedHistoryKey(dataFeed, int64(toHeight)+1))
+	if err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Reading history of %v: %v", dataFeed, err))
+	}
+	defer iterator.Close()
+
+	page := QueryPage{Offset: offset, Items: make([]QueryItem, 0, limit)}
+	for ; iterator.Valid(); iterator.Next() {
+		historyItem := FeedHistoryItem{}
+		if err := json.Unmarshal(iterator.Value(), &historyItem); err != nil {
+			return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Decoding history of %v: %v", dataFeed, err))
+		}
+		if historyItem.Timestamp < fromTimestamp {
+			continue
+		}
+		if historyItem.Timestamp > toTimestamp {
+			break // Timestamps of a feed only increase
+		}
+
+		if page.Total >= offset && page.Total < offset+limit {
+			page.Items = append(page.Items, QueryItem{Key: strconv.FormatInt(historyItem.Height, 10), Value: iterator.Value()})
+		}
+		page.Total++
+	}
+	if err := iterator.Error(); err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Reading history of %v: %v", dataFeed, err))
+	}
+	return app.queryResult([]byte(dataFeedPrefix+dataFeed), page)
+}
+
+func uintParam(params url.Values, name string, defaultValue uint64) (uint64, error) {
+	if !params.Has(name) {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseUint(params.Get(name), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %v %v", name, params.Get(name))
+	}
+	return value, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/libs/bytes"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+)
+
+// Liveness
+// Every validator in the last commit either signed the block or is absent, the last Params.SignedBlocksWindow blocks are tracked per validator
+// Validators that miss more than allowed by Params.MinSignedPerWindow are slashed with Params.SlashFractionDowntime and jailed
+// Tracking starts when a validator first appears in a commit, so a new or unjailed validator always has a full window before it can be jailed
+
+type SigningInfo struct {
+	StartHeight         int64  // Height at which tracking started
+	IndexOffset         int64  // Blocks tracked since StartHeight, the position in MissedBlocks is IndexOffset % SignedBlocksWindow
+	MissedBlocks        []byte // Bit array of SignedBlocksWindow bits, set if the block was missed
+	MissedBlocksCounter int64  // Set bits in MissedBlocks
+}
+
+// Records whether the validator signed the last block, jails it if it missed too many blocks
+func (app *Application) trackLiveness(vote types.VoteInfo, block *blockContext) []types.Event {
+	address := bytes.HexBytes(vote.Validator.Address).String()
+	validator, exists := app.Validators[address]
+	if !exists || validator.Jailed {
+		return nil // Still in the commit until its removal from the validator set takes effect
+	}
+
+	window := app.Params.SignedBlocksWindow
+	info, tracked := app.SigningInfos[address]
+	if !tracked {
+		info = SigningInfo{StartHeight: block.Height, MissedBlocks: make([]byte, (window+7)/8)}
+	}
+
+	index := info.IndexOffset % window
+	info.IndexOffset++
+	previouslyMissed := info.MissedBlocks[index/8]&(1<<(index%8)) != 0
+	missed := vote.BlockIdFlag == cmtproto.BlockIDFlagAbsent
+	switch {
+	case !previouslyMissed && missed:
+		info.MissedBlocks[index/8] |= 1 << (index % 8)
+		info.MissedBlocksCounter++
+	case previouslyMissed && !missed:
+		info.MissedBlocks[index/8] &^= 1 << (index % 8)
+		info.MissedBlocksCounter--
+	}
+
+	maxMissed := window - mulDiv(window, app.Params.MinSignedPerWindow, basisPoints, false)
+	if block.Height < info.StartHeight+window || info.MissedBlocksCounter <= maxMissed {
+		app.SigningInfos[address] = info
+		app.markDirty(signingInfoPrefix + address)
+		return nil
+	}
+
+	// Downtime is not tombstoned, the validator can unjail and starts with a clean window
+	delete(app.SigningInfos, address)
+	app.markDirty(signingInfoPrefix + address)
+	app.slashAndJail(address, block.Height-1, app.Params.powerTokens(vote.Validator.Power), app.Params.SlashFractionDowntime, block)
+
+	event := types.Event{Type: "Validator Slashed", Attributes: make([]types.EventAttribute, 4)}
+	event.Attributes[0] = types.EventAttribute{Key: "validator", Value: address}
+	event.Attributes[1] = types.EventAttribute{Key: "reason", Value: "DOWNTIME"}
+	event.Attributes[2] = types.EventAttribute{Key: "height", Value: fmt.Sprintf("%d", block.Height-1)}
+	event.Attributes[3] = types.EventAttribute{Key: "missedblocks", Value: fmt.Sprintf("%d", info.MissedBlocksCounter)}
+	return []types.Event{event}
+}
+
+// a * b / c without overflowing
+func mulDiv(a int64, b int64, c int64, roundUp bool) int64 {
+	product := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	quotient, remainder := new(big.Int).QuoRem(product, big.NewInt(c), new(big.Int))
+	if roundUp && remainder.Sign() > 0 {
+		quotient.Add(quotient, big.NewInt(1))
+	}
+	return quotient.Int64()
+}
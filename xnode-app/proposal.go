@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"log"
+	"sort"
+
+	"github.com/cometbft/cometbft/abci/types"
+
+	"tendermint-app/txcodec"
+)
+
+// Proposals
+// The proposer executes its candidate transactions on a copy of the state and only proposes the ones that succeed
+// Other validators execute the proposal the same way and reject it if any transaction fails
+func (app *Application) PrepareProposal(_ context.Context, req *types.RequestPrepareProposal) (*types.ResponsePrepareProposal, error) {
+	candidates := make([][]byte, 0, len(req.Txs)+1)
+	if hasVoteExtensions(req.LocalLastCommit) {
+		attestTx, err := attestDataTransaction(req.LocalLastCommit)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, attestTx)
+	}
+	candidates = append(candidates, app.orderTransactions(req.Txs)...)
+
+	txs := make([][]byte, 0, len(candidates))
+	var totalBytes int64
+	err := app.simulate(func() {
+		block := newBlockContext(req.Height, req.Time, req.ProposerAddress, extendedCommitInfoToCommitInfo(req.LocalLastCommit))
+		for _, tx := range candidates {
+			if totalBytes+int64(len(tx)) > req.MaxTxBytes {
+				continue // A smaller transaction might still fit
+			}
+
+			// Rejected transactions do not change the state, data that is not attested yet stays in the mempool until it is
+			result := app.deliverTx(tx, block)
+			if result.Code != CodeTypeOK {
+				continue
+			}
+			txs = append(txs, tx)
+			totalBytes += int64(len(tx))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &types.ResponsePrepareProposal{Txs: txs}, nil
+}
+
+func (app *Application) ProcessProposal(_ context.Context, req *types.RequestProcessProposal) (*types.ResponseProcessProposal, error) {
+	status := types.ResponseProcessProposal_ACCEPT
+	err := app.simulate(func() {
+		block := newBlockContext(req.Height, req.Time, req.ProposerAddress, &req.ProposedLastCommit)
+		seen := make(map[[sha256.Size]byte]bool)
+		for i, tx := range req.Txs {
+			hash := sha256.Sum256(tx)
+			if seen[hash] {
+				log.Printf("Rejecting proposal at height %d with duplicate transaction %d", req.Height, i)
+				status = types.ResponseProcessProposal_REJECT
+				return
+			}
+			seen[hash] = true
+
+			// Attestations are only accepted as the first transaction
+			if decoded, err := app.decodeTx(tx); i != 0 && err == nil && decoded.Type() == txcodec.TypeAttestData {
+				log.Printf("Rejecting proposal at height %d with attestations at transaction %d", req.Height, i)
+				status = types.ResponseProcessProposal_REJECT
+				return
+			}
+
+			result := app.deliverTx(tx, block)
+			if result.Code != CodeTypeOK {
+				log.Printf("Rejecting proposal at height %d with invalid transaction %d (code %d): %v", req.Height, i, result.Code, result.Log)
+				status = types.ResponseProcessProposal_REJECT
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &types.ResponseProcessProposal{Status: status}, nil
+}
+
+// Removes duplicate, undecodable and outdated transactions from the mempool transactions
+// Data transactions go first, newest first per data feed, so older data of the same feed in the block is dropped as outdated
+func (app *Application) orderTransactions(mempoolTxs [][]byte) [][]byte {
+	type dataTransaction struct {
+		Tx   []byte
+		Data *txcodec.ValidateDataTx
+	}
+	dataTxs := make([]dataTransaction, 0)
+	otherTxs := make([][]byte, 0, len(mempoolTxs))
+	seen := make(map[[sha256.Size]byte]bool)
+	seenData := make(map[DataObservation]bool)
+	for _, tx := range mempoolTxs {
+		hash := sha256.Sum256(tx)
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		decoded, err := app.decodeTx(tx)
+		if err != nil {
+			continue
+		}
+		switch validateDataTx := decoded.(type) {
+		case *txcodec.AttestDataTx:
+			continue // Only we can add these
+		case *txcodec.ValidateDataTx:
+			if validateDataTx.DataTimestamp <= app.VerifiedData[validateDataTx.DataFeed].Timestamp {
+				continue
+			}
+			observation := DataObservation{DataFeed: validateDataTx.DataFeed, DataValue: validateDataTx.DataValue, DataTimestamp: validateDataTx.DataTimestamp}
+			if seenData[observation] {
+				continue
+			}
+			seenData[observation] = true
+			dataTxs = append(dataTxs, dataTransaction{Tx: tx, Data: validateDataTx})
+		default:
+			otherTxs = append(otherTxs, tx)
+		}
+	}
+
+	sort.SliceStable(dataTxs, func(i, j int) bool {
+		if dataTxs[i].Data.DataFeed != dataTxs[j].Data.DataFeed {
+			return dataTxs[i].Data.DataFeed < dataTxs[j].Data.DataFeed
+		}
+		return dataTxs[i].Data.DataTimestamp > dataTxs[j].Data.DataTimestamp
+	})
+	txs := make([][]byte, 0, len(dataTxs)+len(otherTxs))
+	for _, dataTx := range dataTxs {
+		txs = append(txs, dataTx.Tx)
+	}
+	return append(txs, otherTxs...)
+}
+
+func extendedCommitInfoToCommitInfo(commit types.ExtendedCommitInfo) *types.CommitInfo {
+	commitInfo := &types.CommitInfo{Round: commit.Round, Votes: make([]types.VoteInfo, len(commit.Votes))}
+	for i, vote := range commit.Votes {
+		commitInfo.Votes[i] = types.VoteInfo{Validator: vote.Validator, BlockIdFlag: vote.BlockIdFlag}
+	}
+	return commitInfo
+}
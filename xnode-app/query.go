@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cometbft/cometbft/abci/types"
+	"github.com/ethereum/go-ethereum/common"
+
+	"tendermint-app/u256"
+)
+
+// Query paths (JSON encoded responses of the last committed state)
+//
+//	tx                                 total amount of executed transactions
+//	validator/<address>                AbciValidator
+//	validators                         QueryPage of AbciValidator
+//	activeset                          QueryPage of consensus power by validator address
+//	feed/<datafeed>                    VerifiedDataItem, at req.Height if set
+//	feeds                              QueryPage of VerifiedDataItem
+//	feedhistory/<datafeed>             QueryPage of FeedHistoryItem, filtered with ?fromheight=&toheight=&from=&to= (timestamps)
+//	account/<address>                  AccountInfo, including what is needed to sign a transaction (CometBFT or checksummed Ethereum address)
+//	accounts                           QueryPage of Account
+//	transfers/<address>                QueryPage of TransferItem sent or received by the address, from the tx indexer, filtered with ?fromheight=&toheight=
+//	deposit/<txhash>                   DepositRecord, attested deposit with its claim status
+//	deposits/<address>                 QueryPage of DepositRecord by transaction hash, deposited by the Ethereum address, filtered with ?claimed=true|false
+//	withdrawals/<address>              []Withdrawal to the Ethereum address, the index is the OpenWithdrawing nonce
+//	withdrawal/<address>/<nonce>       WithdrawalVoucher with the v, r, s for OpenWithdrawing.withdraw (only on nodes with the signer key)
+//	delegations/<validator>            QueryPage of delegated shares by delegator address
+//	delegation/<validator>/<delegator> DelegationInfo
+//	unbonding/<validator>              []UnbondingEntry, tokens leaving the validator that can still be slashed
+//	signinginfo/<validator>            SigningInfo, the missed blocks of the validator in the recent blocks
+//	signinginfos                       QueryPage of SigningInfo
+//	params                             Params
+//	communitypool                      Tokens in the community pool
+//
+// Lists support pagination with ?offset=<n>&limit=<n>
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+type QueryItem struct {
+	Key   string // Address or data feed
+	Value json.RawMessage
+}
+
+type QueryPage struct {
+	Total  int // Items across all pages
+	Offset int
+	Items  []QueryItem
+}
+
+type AccountInfo struct {
+	Address       string
+	Tokens        u256.Int
+	Nonce         uint64
+	AccountNumber uint64 // Nonce and account number are needed to sign transactions
+	Rewards       u256.Int
+}
+
+type DelegationInfo struct {
+	Validator string
+	Delegator string
+	Shares    u256.Int
+	Tokens    u256.Int // What the shares are currently worth
+}
+
+func (app *Application) Query(ctx context.Context, req *types.RequestQuery) (*types.ResponseQuery, error) {
+	path, rawParams, _ := strings.Cut(req.Path, "?")
+	params, err := url.ParseQuery(rawParams)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid query parameters: %v", err)), nil
+	}
+
+	route, argument, _ := strings.Cut(path, "/")
+	if req.Height != 0 && req.Height != app.LastBlockHeight {
+		// Only data feeds keep their history
+		if route != "feed" || req.Height < 0 || req.Height > app.LastBlockHeight {
+			return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Only the latest height (%d) can be queried, got %d", app.LastBlockHeight, req.Height)), nil
+		}
+		return app.queryFeedAtHeight(argument, req.Height), nil
+	}
+
+	switch route {
+	case "tx":
+		return &types.ResponseQuery{Value: []byte(fmt.Sprintf("%v", app.TotalTransactions)), Height: app.LastBlockHeight}, nil
+	case "validator":
+		return app.queryEntry(validatorPrefix + argument), nil
+	case "validators":
+		return app.queryPage(validatorPrefix, params), nil
+	case "activeset":
+		return app.queryPage(activeSetPrefix, params), nil
+	case "feed":
+		return app.queryEntry(dataFeedPrefix + argument), nil
+	case "feeds":
+		return app.queryPage(dataFeedPrefix, params), nil
+	case "feedhistory":
+		return app.queryFeedHistory(argument, params), nil
+	case "account":
+		return app.queryAccount(argument), nil
+	case "accounts":
+		return app.queryPage(accountPrefix, params), nil
+	case "transfers":
+		return app.queryTransfers(ctx, argument, params), nil
+	case "deposit":
+		return app.queryEntry(depositPrefix + argument), nil
+	case "deposits":
+		return app.queryDeposits(argument, params), nil
+	case "withdrawals":
+		return app.queryEntry(withdrawalPrefix + argument), nil
+	case "withdrawal":
+		return app.queryWithdrawalVoucher(argument), nil
+	case "delegations":
+		return app.queryPage(delegationPrefix+argument+"/", params), nil
+	case "delegation":
+		return app.queryDelegation(argument), nil
+	case "unbonding":
+		return app.queryEntry(unbondingPrefix + argument), nil
+	case "signinginfo":
+		return app.queryEntry(signingInfoPrefix + argument), nil
+	case "signinginfos":
+		return app.queryPage(signingInfoPrefix, params), nil
+	case "params":
+		return app.queryEntry(paramsKey), nil
+	case "communitypool":
+		return app.queryEntry(communityPoolKey), nil
+	default:
+		return app.queryError(CodeTypeUnknownQueryPath, fmt.Sprintf("Invalid query path. Expected tx, validator, validators, activeset, feed, feeds, feedhistory, account, accounts, transfers, deposit, deposits, withdrawals, withdrawal, delegations, delegation, unbonding, signinginfo, signinginfos, params or communitypool, got %v", req.Path)), nil
+	}
+}
+
+// Single committed state entry
+func (app *Application) queryEntry(key string) *types.ResponseQuery {
+	value, exists := app.committed[key]
+	if !exists {
+		return app.queryError(CodeTypeNotFound, fmt.Sprintf("%v not found", key))
+	}
+	return &types.ResponseQuery{Key: []byte(key), Value: value, Height: app.LastBlockHeight}
+}
+
+// Page of committed state entries starting with prefix, sorted by key
+func (app *Application) queryPage(prefix string, params url.Values) *types.ResponseQuery {
+	offset, limit, err := pagination(params)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid pagination: %v", err))
+	}
+
+	keys := make([]string, 0)
+	for key := range app.committed {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	page := QueryPage{Total: len(keys), Offset: offset, Items: make([]QueryItem, 0, limit)}
+	for i := offset; i < len(keys) && i < offset+limit; i++ {
+		page.Items = append(page.Items, QueryItem{Key: strings.TrimPrefix(keys[i], prefix), Value: app.committed[keys[i]]})
+	}
+	return app.queryResult([]byte(prefix), page)
+}
+
+// Token balance and nonce of an address
+func (app *Application) queryAccount(address string) *types.ResponseQuery {
+	value, exists := app.committed[accountPrefix+address]
+	if !exists {
+		return app.queryError(CodeTypeNotFound, fmt.Sprintf("Account %v not found", address))
+	}
+	account := Account{}
+	if err := json.Unmarshal(value, &account); err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Decoding account %v: %v", address, err))
+	}
+	return app.queryResult([]byte(address), AccountInfo{Address: address, Tokens: account.Tokens, Nonce: account.Nonce, AccountNumber: account.AccountNumber, Rewards: account.Rewards})
+}
+
+// Attested deposits of an Ethereum address, sorted by transaction hash
+func (app *Application) queryDeposits(address string, params url.Values) *types.ResponseQuery {
+	if !common.IsHexAddress(address) {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid Ethereum address %v", address))
+	}
+	offset, limit, err := pagination(params)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid pagination: %v", err))
+	}
+	var claimed *bool
+	if params.Has("claimed") {
+		value, err := strconv.ParseBool(params.Get("claimed"))
+		if err != nil {
+			return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid claimed filter %v", params.Get("claimed")))
+		}
+		claimed = &value
+	}
+
+	// Only the deposits of the depositor are decoded, they are found through the depositor index
+	depositor := common.HexToAddress(address).Hex()
+	keys := make([]string, 0, len(app.depositors[depositor]))
+	for transactionHash := range app.depositors[depositor] {
+		key := depositPrefix + transactionHash
+		if claimed != nil {
+			deposit := DepositRecord{}
+			if err := json.Unmarshal(app.committed[key], &deposit); err != nil {
+				return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Decoding deposit %v: %v", transactionHash, err))
+			}
+			if deposit.Claimed != *claimed {
+				continue
+			}
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	page := QueryPage{Total: len(keys), Offset: offset, Items: make([]QueryItem, 0, limit)}
+	for i := offset; i < len(keys) && i < offset+limit; i++ {
+		page.Items = append(page.Items, QueryItem{Key: strings.TrimPrefix(keys[i], depositPrefix), Value: app.committed[keys[i]]})
+	}
+	return app.queryResult([]byte(depositor), page)
+}
+
+// Shares of a delegation and the tokens they are worth
+func (app *Application) queryDelegation(argument string) *types.ResponseQuery {
+	validatorAddress, delegatorAddress, _ := strings.Cut(argument, "/")
+	value, exists := app.committed[delegationPrefix+argument]
+	if !exists {
+		return app.queryError(CodeTypeNotFound, fmt.Sprintf("Delegation of %v to %v not found", delegatorAddress, validatorAddress))
+	}
+	delegation := DelegationInfo{Validator: validatorAddress, Delegator: delegatorAddress}
+	if err := json.Unmarshal(value, &delegation.Shares); err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Decoding delegation %v: %v", argument, err))
+	}
+	validator := AbciValidator{}
+	if err := json.Unmarshal(app.committed[validatorPrefix+validatorAddress], &validator); err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Decoding validator %v: %v", validatorAddress, err))
+	}
+	delegation.Tokens = delegationTokens(validator, delegation.Shares)
+	return app.queryResult([]byte(argument), delegation)
+}
+
+func (app *Application) queryResult(key []byte, result interface{}) *types.ResponseQuery {
+	value, err := json.Marshal(result)
+	if err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Encoding query result: %v", err))
+	}
+	return &types.ResponseQuery{Key: key, Value: value, Height: app.LastBlockHeight}
+}
+
+func (app *Application) queryError(code uint32, log string) *types.ResponseQuery {
+	return &types.ResponseQuery{Code: code, Log: log, Height: app.LastBlockHeight}
+}
+
+func pagination(params url.Values) (offset int, limit int, err error) {
+	limit = defaultQueryLimit
+	if params.Has("offset") {
+		offset, err = strconv.Atoi(params.Get("offset"))
+		if err != nil || offset < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %v", params.Get("offset"))
+		}
+	}
+	if params.Has("limit") {
+		limit, err = strconv.Atoi(params.Get("limit"))
+		if err != nil || limit <= 0 || limit > maxQueryLimit {
+			return 0, 0, fmt.Errorf("invalid limit %v (maximum %d)", params.Get("limit"), maxQueryLimit)
+		}
+	}
+	return offset, limit, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/libs/bytes"
+
+	"tendermint-app/u256"
+)
+
+// Slashing
+// Evidence of misbehavior slashes a fraction of the stake the validator had at the infraction height and jails it
+// Jailed validators leave the active set until they send an unjail transaction after Params.JailDuration
+// Validators that are offline too often are jailed as well, see trackLiveness
+// Double signers are tombstoned, they can never unjail and are not slashed again for other evidence
+
+// Fraction of the stake that is slashed for the misbehavior, in basis points
+func (params Params) slashFraction(misbehaviorType types.MisbehaviorType) (int64, bool) {
+	switch misbehaviorType {
+	case types.MisbehaviorType_DUPLICATE_VOTE:
+		return params.SlashFractionDuplicateVote, true
+	case types.MisbehaviorType_LIGHT_CLIENT_ATTACK:
+		return params.SlashFractionLightClientAttack, true
+	}
+	return 0, false
+}
+
+func (app *Application) punishMisbehavior(misbehavior types.Misbehavior, block *blockContext) []types.Event {
+	address := bytes.HexBytes(misbehavior.Validator.Address).String()
+	validator, exists := app.Validators[address]
+	fraction, known := app.Params.slashFraction(misbehavior.Type)
+	if !exists || !known || validator.Tombstoned {
+		return nil
+	}
+
+	app.slashAndJail(address, misbehavior.Height, app.Params.powerTokens(misbehavior.Validator.Power), fraction, block)
+	if misbehavior.Type == types.MisbehaviorType_DUPLICATE_VOTE {
+		validator = app.Validators[address]
+		validator.Tombstoned = true
+		app.Validators[address] = validator
+		app.markDirty(validatorPrefix + address)
+	}
+
+	event := types.Event{Type: "Validator Slashed", Attributes: make([]types.EventAttribute, 4)}
+	event.Attributes[0] = types.EventAttribute{Key: "validator", Value: address}
+	event.Attributes[1] = types.EventAttribute{Key: "reason", Value: misbehavior.Type.String()}
+	event.Attributes[2] = types.EventAttribute{Key: "height", Value: fmt.Sprintf("%d", misbehavior.Height)}
+	event.Attributes[3] = types.EventAttribute{Key: "tombstoned", Value: fmt.Sprintf("%v", validator.Tombstoned)}
+	return []types.Event{event}
+}
+
+// Slashes fraction of the stake the validator had at the infraction height and jails it
+func (app *Application) slashAndJail(address string, infractionHeight int64, infractionStake u256.Int, fraction int64, block *blockContext) {
+	// Stake that was unbonded after the infraction is still in the unbonding queue, it is slashed first
+	// The rest is slashed from the current stake, shares stay the same so all delegators lose the same part of their delegation
+	slashAmount := basisPointsOf(infractionStake, fraction)
+	slashedUnbondings := app.slashUnbondings(address, infractionHeight, fraction)
+	validator := app.Validators[address]
+	if slashAmount.Gt(slashedUnbondings) {
+		slashAmount = u256.Must(slashAmount.Sub(slashedUnbondings)).Min(validator.GovernancePower)
+		validator.GovernancePower = u256.Must(validator.GovernancePower.Sub(slashAmount))
+	}
+
+	validator.Jailed = true
+	validator.JailedUntil = block.Time.Unix() + app.Params.JailDuration
+	app.Validators[address] = validator
+	app.markDirty(validatorPrefix + address)
+
+	// If their GovernancePower is bellow the threshold, return all delegations and give them GovernancePower 0
+	app.removeBelowMinimum(address, block)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/cometbft/cometbft/abci/types"
+)
+
+// State sync
+// Every SnapshotInterval blocks the committed state entries are serialized, split into chunks and stored in the database
+// Nodes that state sync verify every chunk against the hashes in the snapshot metadata and the restored state against the trusted app hash
+const (
+	snapshotFormat    uint32 = 1       // Increase when the snapshot content changes
+	snapshotChunkSize        = 4 << 20 // Bytes per chunk
+)
+
+var snapshotPrefix = []byte("snapshot/") // snapshot/<height>/ -> snapshot info, snapshot/<height>/<chunk> -> chunk
+
+type SnapshotMetadata struct {
+	ChunkHashes [][]byte // SHA256 of every chunk
+}
+
+type snapshotRestore struct {
+	Snapshot    *types.Snapshot
+	AppHash     []byte // Trusted app hash at the snapshot height
+	ChunkHashes [][]byte
+	Chunks      [][]byte
+	Received    int
+}
+
+func snapshotKey(height uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte{}, snapshotPrefix...), height)
+}
+
+func snapshotChunkKey(height uint64, chunk uint32) []byte {
+	return binary.BigEndian.AppendUint32(append(snapshotKey(height), '/'), chunk)
+}
+
+// Stores a snapshot of the committed state, should be called after saveState
+func (app *Application) createSnapshot() error {
+	content, err := json.Marshal(app.committed) // Map keys are sorted, so every node creates the same snapshot
+	if err != nil {
+		return err
+	}
+
+	contentHash := sha256.Sum256(content)
+	height := uint64(app.LastBlockHeight)
+	batch := app.db.NewBatch()
+	defer batch.Close()
+
+	metadata := SnapshotMetadata{}
+	for chunk := uint32(0); len(content) > 0 || chunk == 0; chunk++ {
+		size := min(len(content), snapshotChunkSize)
+		chunkHash := sha256.Sum256(content[:size])
+		metadata.ChunkHashes = append(metadata.ChunkHashes, chunkHash[:])
+		if err := batch.Set(snapshotChunkKey(height, chunk), content[:size]); err != nil {
+			return err
+		}
+		content = content[size:]
+	}
+
+	encodedMetadata, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	snapshot, err := json.Marshal(&types.Snapshot{
+		Height:   height,
+		Format:   snapshotFormat,
+		Chunks:   uint32(len(metadata.ChunkHashes)),
+		Hash:     contentHash[:],
+		Metadata: encodedMetadata,
+	})
+	if err != nil {
+		return err
+	}
+	if err := batch.Set(snapshotKey(height), snapshot); err != nil {
+		return err
+	}
+
+	if err := batch.WriteSync(); err != nil {
+		return err
+	}
+	return app.pruneSnapshots()
+}
+
+// Deletes all but the SnapshotKeepRecent latest snapshots
+func (app *Application) pruneSnapshots() error {
+	snapshots, err := app.snapshots()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) <= int(app.SnapshotKeepRecent) {
+		return nil
+	}
+
+	batch := app.db.NewBatch()
+	defer batch.Close()
+	for _, snapshot := range snapshots[:len(snapshots)-int(app.SnapshotKeepRecent)] {
+		if err := batch.Delete(snapshotKey(snapshot.Height)); err != nil {
+			return err
+		}
+		for chunk := uint32(0); chunk < snapshot.Chunks; chunk++ {
+			if err := batch.Delete(snapshotChunkKey(snapshot.Height, chunk)); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Write()
+}
+
+// All stored snapshots, oldest first
+func (app *Application) snapshots() ([]*types.Snapshot, error) {
+	iterator, err := app.db.Iterator(snapshotPrefix, prefixEnd(snapshotPrefix))
+	if err != nil {
+		return nil, err
+	}
+	defer iterator.Close()
+
+	snapshots := make([]*types.Snapshot, 0)
+	for ; iterator.Valid(); iterator.Next() {
+		if len(iterator.Key()) != len(snapshotKey(0)) {
+			continue // Chunk
+		}
+		snapshot := &types.Snapshot{}
+		if err := json.Unmarshal(iterator.Value(), snapshot); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, iterator.Error()
+}
+
+func (app *Application) ListSnapshots(_ context.Context, req *types.RequestListSnapshots) (*types.ResponseListSnapshots, error) {
+	snapshots, err := app.snapshots()
+	if err != nil {
+		return nil, err
+	}
+	return &types.ResponseListSnapshots{Snapshots: snapshots}, nil
+}
+
+func (app *Application) LoadSnapshotChunk(_ context.Context, req *types.RequestLoadSnapshotChunk) (*types.ResponseLoadSnapshotChunk, error) {
+	if req.Format != snapshotFormat {
+		return &types.ResponseLoadSnapshotChunk{}, nil
+	}
+	chunk, err := app.db.Get(snapshotChunkKey(req.Height, req.Chunk))
+	if err != nil {
+		return nil, err
+	}
+	return &types.ResponseLoadSnapshotChunk{Chunk: chunk}, nil
+}
+
+func (app *Application) OfferSnapshot(_ context.Context, req *types.RequestOfferSnapshot) (*types.ResponseOfferSnapshot, error) {
+	if req.Snapshot == nil {
+		return &types.ResponseOfferSnapshot{Result: types.ResponseOfferSnapshot_REJECT}, nil
+	}
+	if req.Snapshot.Format != snapshotFormat {
+		return &types.ResponseOfferSnapshot{Result: types.ResponseOfferSnapshot_REJECT_FORMAT}, nil
+	}
+
+	metadata := SnapshotMetadata{}
+	if err := json.Unmarshal(req.Snapshot.Metadata, &metadata); err != nil || len(metadata.ChunkHashes) != int(req.Snapshot.Chunks) {
+		return &types.ResponseOfferSnapshot{Result: types.ResponseOfferSnapshot_REJECT}, nil
+	}
+
+	app.restore = &snapshotRestore{
+		Snapshot:    req.Snapshot,
+		AppHash:     req.AppHash,
+		ChunkHashes: metadata.ChunkHashes,
+		Chunks:      make([][]byte, req.Snapshot.Chunks),
+	}
+	return &types.ResponseOfferSnapshot{Result: types.ResponseOfferSnapshot_ACCEPT}, nil
+}
+
+func (app *Application) ApplySnapshotChunk(_ context.Context, req *types.RequestApplySnapshotChunk) (*types.ResponseApplySnapshotChunk, error) {
+	restore := app.restore
+	if restore == nil || req.Index >= uint32(len(restore.Chunks)) {
+		return &types.ResponseApplySnapshotChunk{Result: types.ResponseApplySnapshotChunk_ABORT}, nil
+	}
+
+	chunkHash := sha256.Sum256(req.Chunk)
+	if !bytes.Equal(chunkHash[:], restore.ChunkHashes[req.Index]) {
+		// Ask for the chunk again from someone else
+		return &types.ResponseApplySnapshotChunk{
+			Result:        types.ResponseApplySnapshotChunk_RETRY,
+			RefetchChunks: []uint32{req.Index},
+			RejectSenders: []string{req.Sender},
+		}, nil
+	}
+	if restore.Chunks[req.Index] == nil {
+		restore.Received++
+	}
+	restore.Chunks[req.Index] = req.Chunk
+	if restore.Received < len(restore.Chunks) {
+		return &types.ResponseApplySnapshotChunk{Result: types.ResponseApplySnapshotChunk_ACCEPT}, nil
+	}
+
+	// All chunks received, verify and restore the state
+	app.restore = nil
+	content := bytes.Join(restore.Chunks, nil)
+	contentHash := sha256.Sum256(content)
+	if !bytes.Equal(contentHash[:], restore.Snapshot.Hash) {
+		return &types.ResponseApplySnapshotChunk{Result: types.ResponseApplySnapshotChunk_REJECT_SNAPSHOT}, nil
+	}
+
+	entries := make(map[string][]byte)
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return &types.ResponseApplySnapshotChunk{Result: types.ResponseApplySnapshotChunk_REJECT_SNAPSHOT}, nil
+	}
+	tree := newStateTree(entries)
+	if !bytes.Equal(tree.hash(), restore.AppHash) {
+		log.Printf("Snapshot at height %d does not match the trusted app hash", restore.Snapshot.Height)
+		return &types.ResponseApplySnapshotChunk{Result: types.ResponseApplySnapshotChunk_REJECT_SNAPSHOT}, nil
+	}
+	if err := app.restoreEntries(entries); err != nil {
+		return &types.ResponseApplySnapshotChunk{Result: types.ResponseApplySnapshotChunk_REJECT_SNAPSHOT}, nil
+	}
+
+	// Everything that was committed before is replaced
+	for key := range app.committed {
+		app.unsaved[key] = true
+	}
+	for key := range entries {
+		app.unsaved[key] = true
+	}
+	app.finalized = entries
+	app.tree = tree
+	app.LastBlockHeight = int64(restore.Snapshot.Height)
+	app.LastBlockAppHash = restore.AppHash
+	if err := app.saveState(); err != nil {
+		return nil, fmt.Errorf("saving restored state at height %d: %w", app.LastBlockHeight, err)
+	}
+	log.Printf("Restored state from snapshot at height %d", app.LastBlockHeight)
+
+	return &types.ResponseApplySnapshotChunk{Result: types.ResponseApplySnapshotChunk_ACCEPT}, nil
+}
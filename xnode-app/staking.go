@@ -0,0 +1,189 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/cometbft/cometbft/abci/types"
+
+	"tendermint-app/u256"
+)
+
+// Delegations
+// The GovernancePower of a validator is the sum of all tokens delegated to it, including the self delegation of the validator
+// Delegators own shares of that power instead of a fixed amount of tokens, so rewards and slashing are applied to them pro rata
+// Validators with less than minimumValidatorPower are removed, returning all delegations to their delegators
+// Returned tokens are unbonding for Params.UnbondingPeriod, so they can still be slashed for infractions that are not yet punished
+
+// Tokens that left a validator and are not yet returned to the delegator
+type UnbondingEntry struct {
+	Delegator      string
+	Height         int64 // Height at which the unbonding started, infractions before it are slashed from these tokens too
+	CompletionTime int64 // Unix timestamp, the tokens are returned in the first block at or after it
+	InitialTokens  u256.Int
+	Tokens         u256.Int // InitialTokens minus slashing
+}
+
+// Tokens the shares of a validator are worth
+func delegationTokens(validator AbciValidator, shares u256.Int) u256.Int {
+	if validator.DelegatorShares.IsZero() {
+		return u256.Int{}
+	}
+	return u256.Must(shares.MulDiv(validator.GovernancePower, validator.DelegatorShares, false)) // Shares are at most DelegatorShares
+}
+
+// Shares that are needed to receive tokens from a validator, rounded up so nobody can undelegate more than they own
+func delegationShares(validator AbciValidator, tokens u256.Int) u256.Int {
+	if validator.GovernancePower.IsZero() {
+		return u256.Int{}
+	}
+	return u256.Must(tokens.MulDiv(validator.DelegatorShares, validator.GovernancePower, true))
+}
+
+// Moves unstaked tokens of the delegator to the validator, returns the received shares
+func (app *Application) delegate(delegatorAddress string, validatorAddress string, tokens u256.Int) u256.Int {
+	delegator := app.Accounts[delegatorAddress]
+	delegator.Tokens = u256.Must(delegator.Tokens.Sub(tokens))
+	app.Accounts[delegatorAddress] = delegator
+	app.markDirty(accountPrefix + delegatorAddress)
+
+	validator := app.Validators[validatorAddress]
+	shares := tokens
+	if !validator.DelegatorShares.IsZero() && !validator.GovernancePower.IsZero() {
+		shares = u256.Must(tokens.MulDiv(validator.DelegatorShares, validator.GovernancePower, false))
+	}
+	validator.GovernancePower = u256.Must(validator.GovernancePower.Add(tokens))
+	validator.DelegatorShares = u256.Must(validator.DelegatorShares.Add(shares))
+	app.Validators[validatorAddress] = validator
+	app.markDirty(validatorPrefix + validatorAddress)
+
+	if _, exists := app.Delegations[validatorAddress]; !exists {
+		app.Delegations[validatorAddress] = make(map[string]u256.Int)
+	}
+	app.Delegations[validatorAddress][delegatorAddress] = u256.Must(app.Delegations[validatorAddress][delegatorAddress].Add(shares))
+	app.markDirty(delegationPrefix + validatorAddress + "/" + delegatorAddress)
+	return shares
+}
+
+// Starts unbonding the tokens the shares are worth, returns the amount of tokens
+func (app *Application) undelegate(delegatorAddress string, validatorAddress string, shares u256.Int, block *blockContext) u256.Int {
+	validator := app.Validators[validatorAddress]
+	tokens := delegationTokens(validator, shares)
+	if !shares.IsZero() && shares == validator.DelegatorShares {
+		tokens = validator.GovernancePower // The last shares are worth everything that is left, including rounding leftovers
+	}
+	validator.GovernancePower = u256.Must(validator.GovernancePower.Sub(tokens))
+	validator.DelegatorShares = u256.Must(validator.DelegatorShares.Sub(shares))
+	app.Validators[validatorAddress] = validator
+	app.markDirty(validatorPrefix + validatorAddress)
+
+	app.Delegations[validatorAddress][delegatorAddress] = u256.Must(app.Delegations[validatorAddress][delegatorAddress].Sub(shares))
+	app.markDirty(delegationPrefix + validatorAddress + "/" + delegatorAddress)
+	if app.Delegations[validatorAddress][delegatorAddress].IsZero() {
+		delete(app.Delegations[validatorAddress], delegatorAddress)
+	}
+	if len(app.Delegations[validatorAddress]) == 0 {
+		delete(app.Delegations, validatorAddress)
+	}
+
+	if !tokens.IsZero() {
+		app.Unbondings[validatorAddress] = append(app.Unbondings[validatorAddress], UnbondingEntry{
+			Delegator:      delegatorAddress,
+			Height:         block.Height,
+			CompletionTime: block.Time.Unix() + app.Params.UnbondingPeriod,
+			InitialTokens:  tokens,
+			Tokens:         tokens,
+		})
+		app.markDirty(unbondingPrefix + validatorAddress)
+	}
+	return tokens
+}
+
+// Returns all unbonding tokens that completed their unbonding period to their delegators
+func (app *Application) matureUnbondings(block *blockContext) []types.Event {
+	validators := make([]string, 0, len(app.Unbondings))
+	for validatorAddress := range app.Unbondings {
+		validators = append(validators, validatorAddress)
+	}
+	sort.Strings(validators)
+
+	events := make([]types.Event, 0)
+	for _, validatorAddress := range validators {
+		pending := make([]UnbondingEntry, 0, len(app.Unbondings[validatorAddress]))
+		for _, entry := range app.Unbondings[validatorAddress] {
+			if entry.CompletionTime > block.Time.Unix() {
+				pending = append(pending, entry)
+				continue
+			}
+
+			delegator := app.Accounts[entry.Delegator]
+			delegator.Tokens = u256.Must(delegator.Tokens.Add(entry.Tokens))
+			app.Accounts[entry.Delegator] = delegator
+			app.markDirty(accountPrefix + entry.Delegator)
+
+			event := types.Event{Type: "Unbonding Completed", Attributes: make([]types.EventAttribute, 3)}
+			event.Attributes[0] = types.EventAttribute{Key: "delegator", Value: entry.Delegator}
+			event.Attributes[1] = types.EventAttribute{Key: "validator", Value: validatorAddress}
+			event.Attributes[2] = types.EventAttribute{Key: "amount", Value: entry.Tokens.String()}
+			events = append(events, event)
+		}
+
+		if len(pending) == len(app.Unbondings[validatorAddress]) {
+			continue // Nothing completed, so the entry stays unchanged
+		}
+		app.markDirty(unbondingPrefix + validatorAddress)
+		if len(pending) == 0 {
+			delete(app.Unbondings, validatorAddress)
+		} else {
+			app.Unbondings[validatorAddress] = pending
+		}
+	}
+	return events
+}
+
+// Slashes the unbonding tokens of a validator that were still bonded at the infraction height, returns the slashed amount
+func (app *Application) slashUnbondings(validatorAddress string, infractionHeight int64, fraction int64) u256.Int {
+	slashed := u256.Int{}
+	for i, entry := range app.Unbondings[validatorAddress] {
+		if entry.Height < infractionHeight {
+			continue // Unbonding started before the infraction
+		}
+		// Based on the initial tokens, they were all bonded at the infraction height
+		slashAmount := basisPointsOf(entry.InitialTokens, fraction).Min(entry.Tokens)
+		app.Unbondings[validatorAddress][i].Tokens = u256.Must(entry.Tokens.Sub(slashAmount))
+		app.markDirty(unbondingPrefix + validatorAddress)
+		slashed = u256.Must(slashed.Add(slashAmount))
+	}
+	return slashed
+}
+
+// Returns all delegations of a validator with less than minimumValidatorPower, giving it power 0
+func (app *Application) removeBelowMinimum(validatorAddress string, block *blockContext) {
+	validator, exists := app.Validators[validatorAddress]
+	if !exists || !validator.GovernancePower.Lt(minimumValidatorPower) {
+		return // Nothing to remove, a missing validator must not be written back without a key
+	}
+
+	// Sorted, as the order changes how the tokens are rounded and the last delegator receives the rounding leftovers
+	delegators := make([]string, 0, len(app.Delegations[validatorAddress]))
+	for delegatorAddress := range app.Delegations[validatorAddress] {
+		delegators = append(delegators, delegatorAddress)
+	}
+	sort.Strings(delegators)
+	for _, delegatorAddress := range delegators {
+		app.undelegate(delegatorAddress, validatorAddress, app.Delegations[validatorAddress][delegatorAddress], block)
+	}
+	validator = app.Validators[validatorAddress]
+	if validator.GovernancePower.IsZero() && validator.DelegatorShares.IsZero() {
+		return
+	}
+	app.CommunityPool = u256.Must(app.CommunityPool.Add(validator.GovernancePower)) // Power no delegator owns, so no tokens are lost
+	validator.GovernancePower = u256.Int{}
+	validator.DelegatorShares = u256.Int{}
+	app.Validators[validatorAddress] = validator
+	app.markDirty(validatorPrefix + validatorAddress)
+}
+
+// Fraction of an amount, fraction is between 0 and basisPoints so the result is at most the amount
+func basisPointsOf(amount u256.Int, fraction int64) u256.Int {
+	return u256.Must(amount.MulDiv(u256.New(uint64(fraction)), u256.New(basisPoints), false))
+}
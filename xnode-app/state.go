@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"tendermint-app/u256"
+)
+
+// Database layout
+// Consensus state is stored as one JSON encoded entry per item, so only changed items have to be rewritten on commit
+// Writes mark the entries they change as dirty, only those are serialized and hashed again when a block is finalized
+const (
+	statePrefix = "state/" // All consensus state entries live under this prefix
+
+	accountPrefix    = "account/"    // account/<address> -> Account
+	validatorPrefix  = "validator/"  // validator/<address> -> AbciValidator
+	dataFeedPrefix   = "feed/"       // feed/<datafeed> -> VerifiedDataItem
+	attestedPrefix   = "attested/"   // attested/<datafeed> -> timestamp -> data
+	depositPrefix    = "deposit/"    // deposit/<transaction hash> -> DepositRecord, attested and possibly claimed
+	withdrawalPrefix = "withdrawal/" // withdrawal/<ethereum address> -> []Withdrawal
+
+	delegationPrefix = "delegation/" // delegation/<validator>/<delegator> -> shares
+	unbondingPrefix  = "unbonding/"  // unbonding/<validator> -> []UnbondingEntry
+
+	signingInfoPrefix = "signinginfo/" // signinginfo/<validator> -> SigningInfo
+	activeSetPrefix   = "activeset/"   // activeset/<validator> -> consensus power
+
+	chainIDKey           = "chainid"
+	totalTransactionsKey = "totaltransactions"
+	nextAccountNumberKey = "nextaccountnumber"
+	paramsKey            = "params"
+	communityPoolKey     = "communitypool"
+	inflationTimeKey     = "inflationtime"
+)
+
+var (
+	lastBlockHeightKey  = []byte("lastblockheight")
+	lastBlockAppHashKey = []byte("lastblockapphash")
+)
+
+// Entries that are not items of a map, they are always serialized when the state is finalized
+var scalarKeys = []string{chainIDKey, totalTransactionsKey, nextAccountNumberKey, paramsKey, communityPoolKey, inflationTimeKey}
+
+// Marks a state entry as changed, every write to the in memory state has to mark the entries it changed
+// so they are serialized again when the state is finalized
+func (app *Application) markDirty(key string) {
+	app.dirty[key] = true
+}
+
+// Serializes a single state entry, exists is false if the state does not contain it
+func (app *Application) stateEntry(key string) (value []byte, exists bool, err error) {
+	var item any
+	exists = true
+	switch {
+	case key == chainIDKey:
+		item = app.ChainID
+	case key == totalTransactionsKey:
+		item = app.TotalTransactions
+	case key == nextAccountNumberKey:
+		item = app.NextAccountNumber
+	case key == paramsKey:
+		item = app.Params
+	case key == communityPoolKey:
+		item = app.CommunityPool
+	case key == inflationTimeKey:
+		item = app.InflationTime
+	case strings.HasPrefix(key, validatorPrefix):
+		item, exists = app.Validators[strings.TrimPrefix(key, validatorPrefix)]
+	case strings.HasPrefix(key, dataFeedPrefix):
+		item, exists = app.VerifiedData[strings.TrimPrefix(key, dataFeedPrefix)]
+	case strings.HasPrefix(key, attestedPrefix):
+		item, exists = app.AttestedData[strings.TrimPrefix(key, attestedPrefix)]
+	case strings.HasPrefix(key, depositPrefix):
+		item, exists = app.Deposits[strings.TrimPrefix(key, depositPrefix)]
+	case strings.HasPrefix(key, withdrawalPrefix):
+		item, exists = app.Withdrawals[strings.TrimPrefix(key, withdrawalPrefix)]
+	case strings.HasPrefix(key, delegationPrefix):
+		validatorAddress, delegatorAddress, _ := strings.Cut(strings.TrimPrefix(key, delegationPrefix), "/")
+		item, exists = app.Delegations[validatorAddress][delegatorAddress]
+	case strings.HasPrefix(key, unbondingPrefix):
+		item, exists = app.Unbondings[strings.TrimPrefix(key, unbondingPrefix)]
+	case strings.HasPrefix(key, signingInfoPrefix):
+		item, exists = app.SigningInfos[strings.TrimPrefix(key, signingInfoPrefix)]
+	case strings.HasPrefix(key, activeSetPrefix):
+		item, exists = app.ActiveSet[strings.TrimPrefix(key, activeSetPrefix)]
+	case strings.HasPrefix(key, accountPrefix):
+		item, exists = app.Accounts[strings.TrimPrefix(key, accountPrefix)]
+	default:
+		return nil, false, fmt.Errorf("unknown state entry %v", key)
+	}
+	if !exists {
+		return nil, false, nil
+	}
+
+	value, err = json.Marshal(item)
+	if err != nil {
+		return nil, false, fmt.Errorf("encoding state entry %v: %w", key, err)
+	}
+	return value, true, nil
+}
+
+// Serializes the changed entries and calculates the app hash, the entries are kept until they are saved on commit
+func (app *Application) finalizeState() ([]byte, error) {
+	for _, key := range scalarKeys {
+		app.markDirty(key)
+	}
+	for key := range app.dirty {
+		value, exists, err := app.stateEntry(key)
+		if err != nil {
+			return nil, err
+		}
+		if finalizedValue, finalized := app.finalized[key]; finalized == exists && bytes.Equal(finalizedValue, value) {
+			continue
+		}
+
+		if exists {
+			app.finalized[key] = value
+		} else {
+			delete(app.finalized, key)
+		}
+		app.tree.set(key, value)
+		app.unsaved[key] = true
+	}
+	clear(app.dirty)
+	return app.tree.hash(), nil
+}
+
+// Writes all finalized state entries that changed since the last commit, together with the block height and app hash
+func (app *Application) saveState() error {
+	batch := app.db.NewBatch()
+	defer batch.Close()
+
+	changed := make([]string, 0, len(app.unsaved))
+	for key := range app.unsaved {
+		value, exists := app.finalized[key]
+		if committedValue, committed := app.committed[key]; committed == exists && bytes.Equal(committedValue, value) {
+			continue
+		}
+		changed = append(changed, key)
+		if !exists {
+			if err := batch.Delete([]byte(statePrefix + key)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := batch.Set([]byte(statePrefix+key), value); err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(key, dataFeedPrefix) {
+			dataFeed := strings.TrimPrefix(key, dataFeedPrefix)
+			if err := app.writeFeedHistory(batch, dataFeed, app.VerifiedData[dataFeed]); err != nil {
+				return fmt.Errorf("writing history of %v: %w", dataFeed, err)
+			}
+		}
+	}
+
+	height := make([]byte, 8)
+	binary.BigEndian.PutUint64(height, uint64(app.LastBlockHeight))
+	if err := batch.Set(lastBlockHeightKey, height); err != nil {
+		return err
+	}
+	if err := batch.Set(lastBlockAppHashKey, app.LastBlockAppHash); err != nil {
+		return err
+	}
+
+	if err := batch.WriteSync(); err != nil {
+		return err
+	}
+
+	for _, key := range changed {
+		value, exists := app.finalized[key]
+		if err := app.indexCommittedEntry(key, app.committed[key], value); err != nil {
+			return err
+		}
+		if exists {
+			app.committed[key] = value
+		} else {
+			delete(app.committed, key)
+		}
+	}
+	clear(app.unsaved)
+	return nil
+}
+
+// Restores the last committed state from the database
+func (app *Application) loadState() error {
+	height, err := app.db.Get(lastBlockHeightKey)
+	if err != nil {
+		return err
+	}
+	if height == nil {
+		// Fresh database, state will be created by InitChain
+		return nil
+	}
+	app.LastBlockHeight = int64(binary.BigEndian.Uint64(height))
+
+	app.LastBlockAppHash, err = app.db.Get(lastBlockAppHashKey)
+	if err != nil {
+		return err
+	}
+
+	entries := make(map[string][]byte)
+	iterator, err := app.db.Iterator([]byte(statePrefix), prefixEnd([]byte(statePrefix)))
+	if err != nil {
+		return err
+	}
+	defer iterator.Close()
+	for ; iterator.Valid(); iterator.Next() {
+		entries[string(iterator.Key()[len(statePrefix):])] = iterator.Value()
+	}
+	if err := iterator.Error(); err != nil {
+		return err
+	}
+
+	if err := app.restoreEntries(entries); err != nil {
+		return err
+	}
+	for key, value := range entries {
+		if err := app.indexCommittedEntry(key, nil, value); err != nil {
+			return err
+		}
+	}
+	app.committed = entries
+	app.finalized = maps.Clone(entries)
+	app.tree = newStateTree(entries)
+	return nil
+}
+
+// Keeps the indexes of the committed state up to date with a changed entry, old or value is nil if the entry is created or deleted
+func (app *Application) indexCommittedEntry(key string, old []byte, value []byte) error {
+	if !strings.HasPrefix(key, depositPrefix) {
+		return nil
+	}
+	transactionHash := strings.TrimPrefix(key, depositPrefix)
+	if old != nil {
+		deposit := DepositRecord{}
+		if err := json.Unmarshal(old, &deposit); err != nil {
+			return fmt.Errorf("decoding deposit %v: %w", transactionHash, err)
+		}
+		delete(app.depositors[deposit.Address], transactionHash)
+		if len(app.depositors[deposit.Address]) == 0 {
+			delete(app.depositors, deposit.Address)
+		}
+	}
+	if value != nil {
+		deposit := DepositRecord{}
+		if err := json.Unmarshal(value, &deposit); err != nil {
+			return fmt.Errorf("decoding deposit %v: %w", transactionHash, err)
+		}
+		if _, exists := app.depositors[deposit.Address]; !exists {
+			app.depositors[deposit.Address] = make(map[string]bool)
+		}
+		app.depositors[deposit.Address][transactionHash] = true
+	}
+	return nil
+}
+
+// Replaces the in memory state with the state described by the entries
+func (app *Application) restoreEntries(entries map[string][]byte) error {
+	app.Accounts = make(map[string]Account)
+	app.Validators = make(map[string]AbciValidator)
+	app.VerifiedData = make(map[string]VerifiedDataItem)
+	app.AttestedData = make(map[string]map[uint64]string)
+	app.Deposits = make(map[string]DepositRecord)
+	app.Withdrawals = make(map[string][]Withdrawal)
+	app.Delegations = make(map[string]map[string]u256.Int)
+	app.Unbondings = make(map[string][]UnbondingEntry)
+	app.SigningInfos = make(map[string]SigningInfo)
+	app.ActiveSet = make(map[string]int64)
+	app.Params = Params{}
+	app.CommunityPool = u256.Int{}
+	app.InflationTime = time.Time{}
+	app.ChainID = ""
+	app.TotalTransactions = 0
+	app.NextAccountNumber = 0
+	clear(app.dirty)
+
+	for key, value := range entries {
+		if err := app.restoreEntry(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replaces a single item of the in memory state with the entry, a nil value deletes the item
+func (app *Application) restoreEntry(key string, value []byte) error {
+	var err error
+	switch {
+	case key == chainIDKey:
+		err = json.Unmarshal(value, &app.ChainID)
+	case key == totalTransactionsKey:
+		err = json.Unmarshal(value, &app.TotalTransactions)
+	case key == nextAccountNumberKey:
+		err = json.Unmarshal(value, &app.NextAccountNumber)
+	case key == paramsKey:
+		err = json.Unmarshal(value, &app.Params)
+	case key == communityPoolKey:
+		err = json.Unmarshal(value, &app.CommunityPool)
+	case key == inflationTimeKey:
+		err = json.Unmarshal(value, &app.InflationTime)
+	case strings.HasPrefix(key, validatorPrefix):
+		err = restoreItem(app.Validators, strings.TrimPrefix(key, validatorPrefix), value)
+	case strings.HasPrefix(key, dataFeedPrefix):
+		err = restoreItem(app.VerifiedData, strings.TrimPrefix(key, dataFeedPrefix), value)
+	case strings.HasPrefix(key, attestedPrefix):
+		err = restoreItem(app.AttestedData, strings.TrimPrefix(key, attestedPrefix), value)
+	case strings.HasPrefix(key, depositPrefix):
+		err = restoreItem(app.Deposits, strings.TrimPrefix(key, depositPrefix), value)
+	case strings.HasPrefix(key, withdrawalPrefix):
+		err = restoreItem(app.Withdrawals, strings.TrimPrefix(key, withdrawalPrefix), value)
+	case strings.HasPrefix(key, delegationPrefix):
+		validatorAddress, delegatorAddress, found := strings.Cut(strings.TrimPrefix(key, delegationPrefix), "/")
+		if !found {
+			err = fmt.Errorf("missing delegator address")
+			break
+		}
+		if _, exists := app.Delegations[validatorAddress]; !exists {
+			app.Delegations[validatorAddress] = make(map[string]u256.Int)
+		}
+		err = restoreItem(app.Delegations[validatorAddress], delegatorAddress, value)
+		if len(app.Delegations[validatorAddress]) == 0 {
+			delete(app.Delegations, validatorAddress)
+		}
+	case strings.HasPrefix(key, unbondingPrefix):
+		err = restoreItem(app.Unbondings, strings.TrimPrefix(key, unbondingPrefix), value)
+	case strings.HasPrefix(key, signingInfoPrefix):
+		err = restoreItem(app.SigningInfos, strings.TrimPrefix(key, signingInfoPrefix), value)
+	case strings.HasPrefix(key, activeSetPrefix):
+		err = restoreItem(app.ActiveSet, strings.TrimPrefix(key, activeSetPrefix), value)
+	case strings.HasPrefix(key, accountPrefix):
+		err = restoreItem(app.Accounts, strings.TrimPrefix(key, accountPrefix), value)
+	default:
+		err = fmt.Errorf("unknown state entry")
+	}
+	if err != nil {
+		return fmt.Errorf("decoding state entry %v: %w", key, err)
+	}
+	return nil
+}
+
+// Decodes an item into the map, a nil value deletes it
+func restoreItem[V any](items map[string]V, key string, value []byte) error {
+	if value == nil {
+		delete(items, key)
+		return nil
+	}
+	var item V
+	if err := json.Unmarshal(value, &item); err != nil {
+		return err
+	}
+	items[key] = item
+	return nil
+}
+
+// Runs execute on the current state and reverts all changes it made afterwards
+// Used to check proposals, only the entries execute changed are restored from the finalized state
+func (app *Application) simulate(execute func()) error {
+	if len(app.dirty) != 0 {
+		return fmt.Errorf("simulating on top of %d changed state entries that are not finalized", len(app.dirty))
+	}
+	execute()
+
+	for _, key := range scalarKeys {
+		app.markDirty(key)
+	}
+	for key := range app.dirty {
+		if err := app.restoreEntry(key, app.finalized[key]); err != nil {
+			return err
+		}
+	}
+	clear(app.dirty)
+	return nil
+}
+
+// First key after all keys starting with prefix (for iterators)
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
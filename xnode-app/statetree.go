@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/cometbft/cometbft/crypto/merkle"
+)
+
+// State tree
+// Entries are spread over stateBuckets buckets by the hash of their key, a bucket hash is the merkle root of its leaves sorted by key
+// The app hash is the root of a complete binary tree over the bucket hashes, so a changed entry only rehashes its bucket and the
+// stateTreeDepth inner nodes above it instead of the whole state
+const (
+	stateTreeDepth = 12
+	stateBuckets   = 1 << stateTreeDepth
+)
+
+type stateTree struct {
+	buckets []map[string][]byte // Bucket -> key -> leaf
+	nodes   [][]byte            // Heap ordered: root at 1, children of node i at 2i and 2i+1, bucket hashes from stateBuckets
+	changed map[int]bool        // Buckets of which the hash is outdated
+}
+
+// Tree over the entries
+func newStateTree(entries map[string][]byte) *stateTree {
+	tree := &stateTree{
+		buckets: make([]map[string][]byte, stateBuckets),
+		nodes:   make([][]byte, 2*stateBuckets),
+		changed: make(map[int]bool, stateBuckets),
+	}
+	for bucket := range tree.buckets {
+		tree.buckets[bucket] = make(map[string][]byte)
+		tree.changed[bucket] = true
+	}
+	for key, value := range entries {
+		tree.set(key, value)
+	}
+	return tree
+}
+
+// Leaf of the state tree: length prefixed key followed by the hash of the value
+func stateLeaf(key string, value []byte) []byte {
+	valueHash := sha256.Sum256(value)
+	leaf := binary.AppendUvarint(nil, uint64(len(key)))
+	leaf = append(leaf, key...)
+	leaf = binary.AppendUvarint(leaf, uint64(len(valueHash)))
+	return append(leaf, valueHash[:]...)
+}
+
+func stateBucket(key string) int {
+	keyHash := sha256.Sum256([]byte(key))
+	return int(binary.BigEndian.Uint16(keyHash[:2]) >> (16 - stateTreeDepth))
+}
+
+// Sets the value of an entry, nil removes it
+func (tree *stateTree) set(key string, value []byte) {
+	bucket := stateBucket(key)
+	if value == nil {
+		delete(tree.buckets[bucket], key)
+	} else {
+		tree.buckets[bucket][key] = stateLeaf(key, value)
+	}
+	tree.changed[bucket] = true
+}
+
+// Root of the tree, only the changed buckets and the nodes above them are rehashed
+func (tree *stateTree) hash() []byte {
+	parents := make(map[int]bool, len(tree.changed))
+	for bucket := range tree.changed {
+		keys := make([]string, 0, len(tree.buckets[bucket]))
+		for key := range tree.buckets[bucket] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		leaves := make([][]byte, len(keys))
+		for i, key := range keys {
+			leaves[i] = tree.buckets[bucket][key]
+		}
+		tree.nodes[stateBuckets+bucket] = merkle.HashFromByteSlices(leaves)
+		parents[(stateBuckets+bucket)/2] = true
+	}
+	clear(tree.changed)
+
+	// Level by level, so both children of a node are up to date before it is hashed
+	for level := 0; level < stateTreeDepth; level++ {
+		next := make(map[int]bool, len(parents))
+		for node := range parents {
+			// Same inner node hash as CometBFT merkle trees
+			inner := sha256.New()
+			inner.Write([]byte{1})
+			inner.Write(tree.nodes[2*node])
+			inner.Write(tree.nodes[2*node+1])
+			tree.nodes[node] = inner.Sum(nil)
+			next[node/2] = true
+		}
+		parents = next
+	}
+	return tree.nodes[1]
+}
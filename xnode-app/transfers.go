@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/cometbft/cometbft/abci/types"
+	cmtquery "github.com/cometbft/cometbft/libs/pubsub/query"
+	cmttypes "github.com/cometbft/cometbft/types"
+
+	"tendermint-app/u256"
+)
+
+// Transfers
+// Unstaked tokens move between accounts with a send transaction
+// The transfer history is not part of the state, CometBFT indexes the Transfer events of the transactions instead
+// They can also be searched directly with tx_search, for example query="Transfer.sender='<address>'"
+const (
+	transferEventType    = "Transfer"
+	transferSenderKey    = "sender"
+	transferRecipientKey = "recipient"
+	transferAmountKey    = "amount"
+)
+
+type TransferItem struct {
+	Height    int64
+	Index     uint32 // Position of the transaction in the block
+	TxHash    string
+	Sender    string
+	Recipient string
+	Amount    u256.Int
+}
+
+// Moves unstaked tokens, the recipient account is created if it does not exist yet
+func (app *Application) transfer(senderAddress string, recipientAddress string, amount u256.Int) {
+	sender := app.Accounts[senderAddress]
+	sender.Tokens = u256.Must(sender.Tokens.Sub(amount))
+	app.Accounts[senderAddress] = sender
+	app.markDirty(accountPrefix + senderAddress)
+
+	recipient, exists := app.Accounts[recipientAddress]
+	if !exists {
+		recipient.AccountNumber = app.newAccountNumber()
+	}
+	recipient.Tokens = u256.Must(recipient.Tokens.Add(amount))
+	app.Accounts[recipientAddress] = recipient
+	app.markDirty(accountPrefix + recipientAddress)
+}
+
+// Indexed by CometBFT, so the transfers of an address can be searched
+func transferEvent(senderAddress string, recipientAddress string, amount u256.Int) types.Event {
+	event := types.Event{Type: transferEventType, Attributes: make([]types.EventAttribute, 3)}
+	event.Attributes[0] = types.EventAttribute{Key: transferSenderKey, Value: senderAddress, Index: true}
+	event.Attributes[1] = types.EventAttribute{Key: transferRecipientKey, Value: recipientAddress, Index: true}
+	event.Attributes[2] = types.EventAttribute{Key: transferAmountKey, Value: amount.String(), Index: true}
+	return event
+}
+
+// Transfers sent or received by an address, oldest first
+func (app *Application) queryTransfers(ctx context.Context, address string, params url.Values) *types.ResponseQuery {
+	if app.TxIndexer == nil {
+		return app.queryError(CodeTypeInvalidQuery, `Transfer history is not available, this node does not index transactions (set indexer = "kv" in the tx_index section of the CometBFT config)`)
+	}
+	// Only known address formats, as the address is part of the search query
+	if !validAccountAddress(address) {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid address %v, expected a CometBFT or checksummed Ethereum address", address))
+	}
+	offset, limit, err := pagination(params)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid pagination: %v", err))
+	}
+	fromHeight, err := uintParam(params, "fromheight", 0)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid query parameters: %v", err))
+	}
+	toHeight, err := uintParam(params, "toheight", uint64(app.LastBlockHeight))
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid query parameters: %v", err))
+	}
+	toHeight = min(toHeight, uint64(app.LastBlockHeight))
+
+	// Transactions sending to yourself are found by both searches
+	results := make(map[string]*types.TxResult)
+	for _, key := range []string{transferSenderKey, transferRecipientKey} {
+		q, err := cmtquery.New(fmt.Sprintf("%s.%s = '%s' AND tx.height >= %d AND tx.height <= %d", transferEventType, key, address, fromHeight, toHeight))
+		if err != nil {
+			return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid transfer search: %v", err))
+		}
+		found, err := app.TxIndexer.Search(ctx, q)
+		if err != nil {
+			return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Searching transfers of %v: %v", address, err))
+		}
+		for _, result := range found {
+			results[fmt.Sprintf("%d/%d", result.Height, result.Index)] = result
+		}
+	}
+
+	transfers := make([]TransferItem, 0, len(results))
+	for _, result := range results {
+		for _, event := range result.Result.Events {
+			if event.Type != transferEventType {
+				continue
+			}
+			transfer := transferItem(result, event)
+			if transfer.Sender == address || transfer.Recipient == address {
+				transfers = append(transfers, transfer)
+			}
+		}
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		if transfers[i].Height != transfers[j].Height {
+			return transfers[i].Height < transfers[j].Height
+		}
+		return transfers[i].Index < transfers[j].Index
+	})
+
+	page := QueryPage{Total: len(transfers), Offset: offset, Items: make([]QueryItem, 0, limit)}
+	for i := offset; i < len(transfers) && i < offset+limit; i++ {
+		value, err := json.Marshal(transfers[i])
+		if err != nil {
+			return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Encoding transfer: %v", err))
+		}
+		page.Items = append(page.Items, QueryItem{Key: transfers[i].TxHash, Value: value})
+	}
+	return app.queryResult([]byte(address), page)
+}
+
+func transferItem(result *types.TxResult, event types.Event) TransferItem {
+	transfer := TransferItem{
+		Height: result.Height,
+		Index:  result.Index,
+		TxHash: fmt.Sprintf("%X", cmttypes.Tx(result.Tx).Hash()),
+	}
+	for _, attribute := range event.Attributes {
+		switch attribute.Key {
+		case transferSenderKey:
+			transfer.Sender = attribute.Value
+		case transferRecipientKey:
+			transfer.Recipient = attribute.Value
+		case transferAmountKey:
+			transfer.Amount, _ = u256.Parse(attribute.Value)
+		}
+	}
+	return transfer
+}
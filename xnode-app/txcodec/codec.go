@@ -0,0 +1,588 @@
+// Package txcodec encodes and decodes the transactions of the xnode app
+//
+// An encoded transaction is a version byte followed by a protobuf Tx message, see tx.proto for the schema
+// Decoding is strict: unknown fields, non canonical encodings and oversized transactions are rejected,
+// so every transaction has exactly one valid encoding
+package txcodec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"github.com/cometbft/cometbft/abci/types"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"tendermint-app/u256"
+)
+
+const Version1 byte = 1 // Current encoding version, first byte of every encoded transaction
+
+const (
+	MaxTxSize           = 16 << 10 // Bytes of an encoded transaction
+	MaxAttestDataTxSize = 16 << 20 // Bytes of an encoded attest data transaction, it contains the vote extensions of all validators
+	MaxFieldSize        = 1024     // Bytes of a single string or bytes field (except for the attested commit)
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported transaction encoding version")
+	ErrUnknownType        = errors.New("unknown transaction type")
+	ErrTooLarge           = errors.New("transaction too large")
+	ErrNonCanonical       = errors.New("non canonical transaction encoding")
+)
+
+// Transaction types, as used by the legacy JSON encoding
+const (
+	TypeValidateData uint8 = 0
+	TypeAttestData   uint8 = 1
+
+	TypeStakeTokens    uint8 = 10
+	TypeClaimTokens    uint8 = 11
+	TypeWithdrawTokens uint8 = 12
+
+	TypeCreateValidator uint8 = 13
+	TypeDelegate        uint8 = 14
+	TypeUndelegate      uint8 = 15
+	TypeUnjail          uint8 = 16
+	TypeWithdrawRewards uint8 = 17
+	TypeSend            uint8 = 18
+)
+
+// Field numbers of the transaction kinds in the Tx message
+const (
+	fieldValidateData    protowire.Number = 1
+	fieldAttestData      protowire.Number = 2
+	fieldStakeTokens     protowire.Number = 10
+	fieldClaimTokens     protowire.Number = 11
+	fieldWithdrawTokens  protowire.Number = 12
+	fieldCreateValidator protowire.Number = 13
+	fieldDelegate        protowire.Number = 14
+	fieldUndelegate      protowire.Number = 15
+	fieldUnjail          protowire.Number = 16
+	fieldWithdrawRewards protowire.Number = 17
+	fieldSend            protowire.Number = 18
+)
+
+// Tx is one of *ValidateDataTx, *AttestDataTx, *StakeTokensTx, *ClaimTokensTx, *WithdrawTokensTx, *CreateValidatorTx,
+// *DelegateTx, *UndelegateTx, *UnjailTx, *WithdrawRewardsTx or *SendTx
+type Tx interface {
+	Type() uint8
+}
+
+// Try to reach consensus about a piece of data
+type ValidateDataTx struct {
+	DataFeed      string
+	DataValue     string
+	DataTimestamp uint64
+}
+
+// Vote extensions of the previous block, only the block proposer can add this transaction
+type AttestDataTx struct {
+	Commit types.ExtendedCommitInfo
+}
+
+// Stake / Unstake tokens
+type StakeTokensTx struct {
+	Amount           u256.Int
+	ValidatorAddress string
+	Unstake          bool // Unstake the amount instead
+	Auth
+}
+
+// Claim tokens by providing ethereum transaction hash, proof is from the ethereum address that deposited their tokens
+type ClaimTokensTx struct {
+	TransactionHash  string
+	ValidatorAddress string // Receiving account
+	Proof            string // EIP-712 signature of the depositor, binding the deposit to the receiver and the chain
+}
+
+// Withdraw unstaked tokens to ethreum blockchain
+type WithdrawTokensTx struct {
+	Amount           u256.Int
+	Address          string
+	ValidatorAddress string
+	Auth
+}
+
+// Register the consensus key of an account, so it can become a validator once it has staked enough tokens
+// The transaction is signed with the consensus key, proving it belongs to the account
+type CreateValidatorTx struct {
+	PubKey         []byte // Ed25519 consensus key, its address is the account becoming a validator
+	Moniker        string
+	CommissionRate uint32 // Share of the delegator rewards for the validator, in basis points
+	Auth
+}
+
+// Delegate unstaked tokens of the delegator to a validator, in exchange for shares of the validator's stake
+type DelegateTx struct {
+	DelegatorAddress string
+	ValidatorAddress string
+	Amount           u256.Int
+	Auth
+}
+
+// Return delegated tokens, the shares are worth their part of the validator's stake (including rewards and slashing)
+type UndelegateTx struct {
+	DelegatorAddress string
+	ValidatorAddress string
+	Shares           u256.Int
+	Auth
+}
+
+// Return a jailed validator to the validator set after its jail time
+type UnjailTx struct {
+	ValidatorAddress string
+	Auth
+}
+
+// Move the accrued staking rewards of an account to its unstaked tokens
+type WithdrawRewardsTx struct {
+	Address string
+	Auth
+}
+
+// Move unstaked tokens to another account, creating it if it does not exist yet
+type SendTx struct {
+	FromAddress string
+	ToAddress   string
+	Amount      u256.Int
+	Auth
+}
+
+func (*ValidateDataTx) Type() uint8    { return TypeValidateData }
+func (*AttestDataTx) Type() uint8      { return TypeAttestData }
+func (*StakeTokensTx) Type() uint8     { return TypeStakeTokens }
+func (*ClaimTokensTx) Type() uint8     { return TypeClaimTokens }
+func (*WithdrawTokensTx) Type() uint8  { return TypeWithdrawTokens }
+func (*CreateValidatorTx) Type() uint8 { return TypeCreateValidator }
+func (*DelegateTx) Type() uint8        { return TypeDelegate }
+func (*UndelegateTx) Type() uint8      { return TypeUndelegate }
+func (*UnjailTx) Type() uint8          { return TypeUnjail }
+func (*WithdrawRewardsTx) Type() uint8 { return TypeWithdrawRewards }
+func (*SendTx) Type() uint8            { return TypeSend }
+
+// Encode returns the canonical encoding of a transaction
+func Encode(tx Tx) ([]byte, error) {
+	var field protowire.Number
+	var body []byte
+	switch tx := tx.(type) {
+	case *ValidateDataTx:
+		field = fieldValidateData
+		body = appendString(body, 1, tx.DataFeed)
+		body = appendString(body, 2, tx.DataValue)
+		body = appendUint(body, 3, tx.DataTimestamp)
+	case *AttestDataTx:
+		field = fieldAttestData
+		commit, err := tx.Commit.Marshal()
+		if err != nil {
+			return nil, fmt.Errorf("encoding attested commit: %w", err)
+		}
+		body = appendBytes(body, 1, commit)
+	case *StakeTokensTx:
+		field = fieldStakeTokens
+		body = appendAmount(body, 1, tx.Amount)
+		body = appendString(body, 2, tx.ValidatorAddress)
+		body = appendBool(body, 3, tx.Unstake)
+		body = appendAuth(body, 4, tx.Auth)
+	case *ClaimTokensTx:
+		field = fieldClaimTokens
+		body = appendString(body, 1, tx.TransactionHash)
+		body = appendString(body, 2, tx.ValidatorAddress)
+		body = appendString(body, 3, tx.Proof)
+	case *WithdrawTokensTx:
+		field = fieldWithdrawTokens
+		body = appendAmount(body, 1, tx.Amount)
+		body = appendString(body, 2, tx.Address)
+		body = appendString(body, 3, tx.ValidatorAddress)
+		body = appendAuth(body, 4, tx.Auth)
+	case *CreateValidatorTx:
+		if len(tx.Auth.PubKey) > 0 {
+			return nil, errors.New("create validator transaction is signed by its consensus key, the account public key field is reserved")
+		}
+		field = fieldCreateValidator
+		body = appendBytes(body, 1, tx.PubKey)
+		body = appendString(body, 2, tx.Moniker)
+		body = appendUint(body, 3, uint64(tx.CommissionRate))
+		body = appendAuth(body, 4, tx.Auth)
+	case *DelegateTx:
+		field = fieldDelegate
+		body = appendString(body, 1, tx.DelegatorAddress)
+		body = appendString(body, 2, tx.ValidatorAddress)
+		body = appendAmount(body, 3, tx.Amount)
+		body = appendAuth(body, 4, tx.Auth)
+	case *UndelegateTx:
+		field = fieldUndelegate
+		body = appendString(body, 1, tx.DelegatorAddress)
+		body = appendString(body, 2, tx.ValidatorAddress)
+		body = appendAmount(body, 3, tx.Shares)
+		body = appendAuth(body, 4, tx.Auth)
+	case *UnjailTx:
+		field = fieldUnjail
+		body = appendString(body, 1, tx.ValidatorAddress)
+		body = appendAuth(body, 2, tx.Auth)
+	case *WithdrawRewardsTx:
+		field = fieldWithdrawRewards
+		body = appendString(body, 1, tx.Address)
+		body = appendAuth(body, 2, tx.Auth)
+	case *SendTx:
+		field = fieldSend
+		body = appendString(body, 1, tx.FromAddress)
+		body = appendString(body, 2, tx.ToAddress)
+		body = appendAmount(body, 3, tx.Amount)
+		body = appendAuth(body, 4, tx.Auth)
+	default:
+		return nil, ErrUnknownType
+	}
+
+	encoded := []byte{Version1}
+	encoded = protowire.AppendTag(encoded, field, protowire.BytesType)
+	return protowire.AppendBytes(encoded, body), nil
+}
+
+// Decode decodes a transaction, only accepting its canonical encoding
+func Decode(encoded []byte) (Tx, error) {
+	if len(encoded) > MaxAttestDataTxSize {
+		return nil, ErrTooLarge
+	}
+	if len(encoded) == 0 || encoded[0] != Version1 {
+		return nil, ErrUnsupportedVersion
+	}
+
+	fields, err := parseMessage(encoded[1:], map[protowire.Number]protowire.Type{
+		fieldValidateData:    protowire.BytesType,
+		fieldAttestData:      protowire.BytesType,
+		fieldStakeTokens:     protowire.BytesType,
+		fieldClaimTokens:     protowire.BytesType,
+		fieldWithdrawTokens:  protowire.BytesType,
+		fieldCreateValidator: protowire.BytesType,
+		fieldDelegate:        protowire.BytesType,
+		fieldUndelegate:      protowire.BytesType,
+		fieldUnjail:          protowire.BytesType,
+		fieldWithdrawRewards: protowire.BytesType,
+		fieldSend:            protowire.BytesType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) != 1 {
+		return nil, fmt.Errorf("%w: expected exactly 1 transaction body, got %d", ErrUnknownType, len(fields))
+	}
+
+	var tx Tx
+	for field, value := range fields {
+		if field != fieldAttestData && len(encoded) > MaxTxSize {
+			return nil, ErrTooLarge
+		}
+		tx, err = decodeBody(field, value.bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	canonical, err := Encode(tx)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(canonical, encoded) {
+		return nil, ErrNonCanonical
+	}
+	return tx, nil
+}
+
+func decodeBody(field protowire.Number, body []byte) (Tx, error) {
+	switch field {
+	case fieldValidateData:
+		fields, err := parseMessage(body, map[protowire.Number]protowire.Type{1: protowire.BytesType, 2: protowire.BytesType, 3: protowire.VarintType})
+		if err != nil {
+			return nil, fmt.Errorf("validate data transaction: %w", err)
+		}
+		tx := &ValidateDataTx{DataTimestamp: fields[3].varint}
+		if tx.DataFeed, err = stringField(fields[1], true); err != nil {
+			return nil, fmt.Errorf("validate data transaction data feed: %w", err)
+		}
+		if tx.DataValue, err = stringField(fields[2], true); err != nil {
+			return nil, fmt.Errorf("validate data transaction data value: %w", err)
+		}
+		return tx, nil
+
+	case fieldAttestData:
+		fields, err := parseMessage(body, map[protowire.Number]protowire.Type{1: protowire.BytesType})
+		if err != nil {
+			return nil, fmt.Errorf("attest data transaction: %w", err)
+		}
+		tx := &AttestDataTx{}
+		if err := tx.Commit.Unmarshal(fields[1].bytes); err != nil {
+			return nil, fmt.Errorf("attest data transaction commit: %w", err)
+		}
+		return tx, nil
+
+	case fieldStakeTokens:
+		fields, err := parseMessage(body, authSchema(4, map[protowire.Number]protowire.Type{1: protowire.BytesType, 2: protowire.BytesType, 3: protowire.VarintType}))
+		if err != nil {
+			return nil, fmt.Errorf("stake tokens transaction: %w", err)
+		}
+		tx := &StakeTokensTx{}
+		if tx.Amount, err = amountField(fields[1]); err != nil {
+			return nil, fmt.Errorf("stake tokens transaction amount: %w", err)
+		}
+		if tx.ValidatorAddress, err = stringField(fields[2], true); err != nil {
+			return nil, fmt.Errorf("stake tokens transaction validator address: %w", err)
+		}
+		if tx.Unstake, err = boolField(fields[3]); err != nil {
+			return nil, fmt.Errorf("stake tokens transaction unstake: %w", err)
+		}
+		if tx.Auth, err = authFields(fields, 4); err != nil {
+			return nil, fmt.Errorf("stake tokens transaction %w", err)
+		}
+		return tx, nil
+
+	case fieldClaimTokens:
+		fields, err := parseMessage(body, map[protowire.Number]protowire.Type{1: protowire.BytesType, 2: protowire.BytesType, 3: protowire.BytesType})
+		if err != nil {
+			return nil, fmt.Errorf("claim tokens transaction: %w", err)
+		}
+		tx := &ClaimTokensTx{}
+		if tx.TransactionHash, err = stringField(fields[1], true); err != nil {
+			return nil, fmt.Errorf("claim tokens transaction transaction hash: %w", err)
+		}
+		if tx.ValidatorAddress, err = stringField(fields[2], true); err != nil {
+			return nil, fmt.Errorf("claim tokens transaction validator address: %w", err)
+		}
+		if tx.Proof, err = stringField(fields[3], false); err != nil {
+			return nil, fmt.Errorf("claim tokens transaction proof: %w", err)
+		}
+		return tx, nil
+
+	case fieldWithdrawTokens:
+		fields, err := parseMessage(body, authSchema(4, map[protowire.Number]protowire.Type{1: protowire.BytesType, 2: protowire.BytesType, 3: protowire.BytesType}))
+		if err != nil {
+			return nil, fmt.Errorf("withdraw tokens transaction: %w", err)
+		}
+		tx := &WithdrawTokensTx{}
+		if tx.Amount, err = amountField(fields[1]); err != nil {
+			return nil, fmt.Errorf("withdraw tokens transaction amount: %w", err)
+		}
+		if tx.Address, err = stringField(fields[2], true); err != nil {
+			return nil, fmt.Errorf("withdraw tokens transaction address: %w", err)
+		}
+		if tx.ValidatorAddress, err = stringField(fields[3], true); err != nil {
+			return nil, fmt.Errorf("withdraw tokens transaction validator address: %w", err)
+		}
+		if tx.Auth, err = authFields(fields, 4); err != nil {
+			return nil, fmt.Errorf("withdraw tokens transaction %w", err)
+		}
+		return tx, nil
+
+	case fieldCreateValidator:
+		schema := authSchema(4, map[protowire.Number]protowire.Type{1: protowire.BytesType, 2: protowire.BytesType, 3: protowire.VarintType})
+		delete(schema, 7) // Reserved, the signer is the consensus key so a public key would be signed but ignored
+		fields, err := parseMessage(body, schema)
+		if err != nil {
+			return nil, fmt.Errorf("create validator transaction: %w", err)
+		}
+		if fields[3].varint > math.MaxUint32 {
+			return nil, errors.New("create validator transaction commission rate: overflow")
+		}
+		tx := &CreateValidatorTx{CommissionRate: uint32(fields[3].varint)}
+		if tx.PubKey, err = bytesField(fields[1]); err != nil {
+			return nil, fmt.Errorf("create validator transaction public key: %w", err)
+		}
+		if tx.Moniker, err = stringField(fields[2], true); err != nil {
+			return nil, fmt.Errorf("create validator transaction moniker: %w", err)
+		}
+		if tx.Auth, err = authFields(fields, 4); err != nil {
+			return nil, fmt.Errorf("create validator transaction %w", err)
+		}
+		return tx, nil
+
+	case fieldDelegate, fieldUndelegate:
+		fields, err := parseMessage(body, authSchema(4, map[protowire.Number]protowire.Type{1: protowire.BytesType, 2: protowire.BytesType, 3: protowire.BytesType}))
+		if err != nil {
+			return nil, fmt.Errorf("delegation transaction: %w", err)
+		}
+		delegatorAddress, err := stringField(fields[1], true)
+		if err != nil {
+			return nil, fmt.Errorf("delegation transaction delegator address: %w", err)
+		}
+		validatorAddress, err := stringField(fields[2], true)
+		if err != nil {
+			return nil, fmt.Errorf("delegation transaction validator address: %w", err)
+		}
+		amount, err := amountField(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("delegation transaction amount: %w", err)
+		}
+		auth, err := authFields(fields, 4)
+		if err != nil {
+			return nil, fmt.Errorf("delegation transaction %w", err)
+		}
+		if field == fieldDelegate {
+			return &DelegateTx{DelegatorAddress: delegatorAddress, ValidatorAddress: validatorAddress, Amount: amount, Auth: auth}, nil
+		}
+		return &UndelegateTx{DelegatorAddress: delegatorAddress, ValidatorAddress: validatorAddress, Shares: amount, Auth: auth}, nil
+
+	case fieldUnjail:
+		fields, err := parseMessage(body, authSchema(2, map[protowire.Number]protowire.Type{1: protowire.BytesType}))
+		if err != nil {
+			return nil, fmt.Errorf("unjail transaction: %w", err)
+		}
+		tx := &UnjailTx{}
+		if tx.ValidatorAddress, err = stringField(fields[1], true); err != nil {
+			return nil, fmt.Errorf("unjail transaction validator address: %w", err)
+		}
+		if tx.Auth, err = authFields(fields, 2); err != nil {
+			return nil, fmt.Errorf("unjail transaction %w", err)
+		}
+		return tx, nil
+
+	case fieldWithdrawRewards:
+		fields, err := parseMessage(body, authSchema(2, map[protowire.Number]protowire.Type{1: protowire.BytesType}))
+		if err != nil {
+			return nil, fmt.Errorf("withdraw rewards transaction: %w", err)
+		}
+		tx := &WithdrawRewardsTx{}
+		if tx.Address, err = stringField(fields[1], true); err != nil {
+			return nil, fmt.Errorf("withdraw rewards transaction address: %w", err)
+		}
+		if tx.Auth, err = authFields(fields, 2); err != nil {
+			return nil, fmt.Errorf("withdraw rewards transaction %w", err)
+		}
+		return tx, nil
+
+	case fieldSend:
+		fields, err := parseMessage(body, authSchema(4, map[protowire.Number]protowire.Type{1: protowire.BytesType, 2: protowire.BytesType, 3: protowire.BytesType}))
+		if err != nil {
+			return nil, fmt.Errorf("send transaction: %w", err)
+		}
+		tx := &SendTx{}
+		if tx.Amount, err = amountField(fields[3]); err != nil {
+			return nil, fmt.Errorf("send transaction amount: %w", err)
+		}
+		if tx.FromAddress, err = stringField(fields[1], true); err != nil {
+			return nil, fmt.Errorf("send transaction from address: %w", err)
+		}
+		if tx.ToAddress, err = stringField(fields[2], true); err != nil {
+			return nil, fmt.Errorf("send transaction to address: %w", err)
+		}
+		if tx.Auth, err = authFields(fields, 4); err != nil {
+			return nil, fmt.Errorf("send transaction %w", err)
+		}
+		return tx, nil
+	}
+	return nil, ErrUnknownType
+}
+
+type fieldValue struct {
+	varint uint64
+	bytes  []byte
+}
+
+// Splits a message into its field values, rejecting unknown fields, unexpected wire types and repeated fields
+func parseMessage(message []byte, schema map[protowire.Number]protowire.Type) (map[protowire.Number]fieldValue, error) {
+	fields := make(map[protowire.Number]fieldValue, len(schema))
+	for len(message) > 0 {
+		field, wireType, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		message = message[n:]
+
+		expectedType, known := schema[field]
+		if !known {
+			return nil, fmt.Errorf("unknown field %d", field)
+		}
+		if wireType != expectedType {
+			return nil, fmt.Errorf("field %d has wire type %d, expected %d", field, wireType, expectedType)
+		}
+		if _, repeated := fields[field]; repeated {
+			return nil, fmt.Errorf("field %d is repeated", field)
+		}
+
+		value := fieldValue{}
+		switch wireType {
+		case protowire.VarintType:
+			value.varint, n = protowire.ConsumeVarint(message)
+		case protowire.BytesType:
+			value.bytes, n = protowire.ConsumeBytes(message)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		message = message[n:]
+		fields[field] = value
+	}
+	return fields, nil
+}
+
+func stringField(value fieldValue, text bool) (string, error) {
+	if len(value.bytes) > MaxFieldSize {
+		return "", fmt.Errorf("%w: %d bytes, maximum %d", ErrTooLarge, len(value.bytes), MaxFieldSize)
+	}
+	if text && !utf8.Valid(value.bytes) {
+		return "", errors.New("invalid UTF-8")
+	}
+	return string(value.bytes), nil
+}
+
+func bytesField(value fieldValue) ([]byte, error) {
+	if len(value.bytes) > MaxFieldSize {
+		return nil, fmt.Errorf("%w: %d bytes, maximum %d", ErrTooLarge, len(value.bytes), MaxFieldSize)
+	}
+	if len(value.bytes) == 0 {
+		return nil, nil
+	}
+	return append([]byte{}, value.bytes...), nil
+}
+
+// Amounts are big-endian bytes without leading zeros, so every amount has one encoding
+func amountField(value fieldValue) (u256.Int, error) {
+	if len(value.bytes) > 0 && value.bytes[0] == 0 {
+		return u256.Int{}, fmt.Errorf("%w: leading zero", ErrNonCanonical)
+	}
+	return u256.FromBytes(value.bytes)
+}
+
+func boolField(value fieldValue) (bool, error) {
+	if value.varint > 1 {
+		return false, fmt.Errorf("%w: bool should be 0 or 1", ErrNonCanonical)
+	}
+	return value.varint == 1, nil
+}
+
+// Default values are omitted, as protobuf does
+func appendString(b []byte, field protowire.Number, value string) []byte {
+	if value == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+func appendBytes(b []byte, field protowire.Number, value []byte) []byte {
+	if len(value) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, field, protowire.BytesType)
+	return protowire.AppendBytes(b, value)
+}
+
+func appendUint(b []byte, field protowire.Number, value uint64) []byte {
+	if value == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, field, protowire.VarintType)
+	return protowire.AppendVarint(b, value)
+}
+
+func appendAmount(b []byte, field protowire.Number, value u256.Int) []byte {
+	return appendBytes(b, field, value.Bytes())
+}
+
+func appendBool(b []byte, field protowire.Number, value bool) []byte {
+	if !value {
+		return b
+	}
+	return appendUint(b, field, 1)
+}
@@ -0,0 +1,181 @@
+package txcodec
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cometbft/cometbft/abci/types"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"tendermint-app/u256"
+)
+
+// Encodings shared with data-mock/encoding.test.js, so both implementations produce the same bytes
+type signDocVector struct {
+	ChainID       string `json:"chainId"`
+	AccountNumber uint64 `json:"accountNumber"`
+	Nonce         uint64 `json:"nonce"`
+	Fee           string `json:"fee"`
+	Memo          string `json:"memo"`
+	Type          uint8  `json:"type"`
+	Body          string `json:"body"`
+	SignBytes     string `json:"signBytes"`
+}
+
+type validateDataVector struct {
+	DataFeed      string `json:"dataFeed"`
+	DataValue     string `json:"dataValue"`
+	DataTimestamp uint64 `json:"dataTimestamp"`
+	Encoded       string `json:"encoded"`
+}
+
+type encodingVectors struct {
+	SignDoc      signDocVector      `json:"signDoc"`
+	ValidateData validateDataVector `json:"validateData"`
+}
+
+func loadEncodingVectors(t *testing.T) encodingVectors {
+	t.Helper()
+	encoded, err := os.ReadFile("testdata/encoding_vectors.json")
+	if err != nil {
+		t.Fatalf("reading encoding vectors: %v", err)
+	}
+	vectors := encodingVectors{}
+	if err := json.Unmarshal(encoded, &vectors); err != nil {
+		t.Fatalf("decoding encoding vectors: %v", err)
+	}
+	return vectors
+}
+
+var testAuth = Auth{Signature: []byte{1, 2, 3}, Fee: u256.New(1_000), Memo: "memo", PubKey: []byte{4, 5, 6}}
+
+// One transaction of every type, with every field set
+func testTransactions() []Tx {
+	return []Tx{
+		&ValidateDataTx{DataFeed: "Binance|BTCUSDT|price", DataValue: "27000.01", DataTimestamp: 1_700_000_000},
+		&AttestDataTx{Commit: types.ExtendedCommitInfo{Round: 1, Votes: []types.ExtendedVoteInfo{{
+			Validator:          types.Validator{Address: []byte{7, 8, 9}, Power: 10},
+			VoteExtension:      []byte("{}"),
+			ExtensionSignature: []byte{10, 11},
+			BlockIdFlag:        cmtproto.BlockIDFlagCommit,
+		}}}},
+		&StakeTokensTx{Amount: u256.New(5), ValidatorAddress: "validator", Unstake: true, Auth: testAuth},
+		&ClaimTokensTx{TransactionHash: "0x01", ValidatorAddress: "receiver", Proof: "0x02"},
+		&WithdrawTokensTx{Amount: u256.New(5), Address: "0x03", ValidatorAddress: "validator", Auth: testAuth},
+		&CreateValidatorTx{PubKey: []byte{12, 13}, Moniker: "moniker", CommissionRate: 500, Auth: Auth{Signature: []byte{1}, Fee: u256.New(1), Memo: "memo"}},
+		&DelegateTx{DelegatorAddress: "delegator", ValidatorAddress: "validator", Amount: u256.New(5), Auth: testAuth},
+		&UndelegateTx{DelegatorAddress: "delegator", ValidatorAddress: "validator", Shares: u256.New(5), Auth: testAuth},
+		&UnjailTx{ValidatorAddress: "validator", Auth: testAuth},
+		&WithdrawRewardsTx{Address: "delegator", Auth: testAuth},
+		&SendTx{FromAddress: "sender", ToAddress: "receiver", Amount: u256.New(5), Auth: testAuth},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tx := range testTransactions() {
+		encoded, err := Encode(tx)
+		if err != nil {
+			t.Fatalf("encoding %T: %v", tx, err)
+		}
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Fatalf("decoding %T: %v", tx, err)
+		}
+		if !reflect.DeepEqual(decoded, tx) {
+			t.Errorf("%T changed by a round trip: %+v, expected %+v", tx, decoded, tx)
+		}
+	}
+}
+
+// Version byte followed by a Tx message with the body in field
+func testEncoding(field protowire.Number, body []byte) []byte {
+	encoded := []byte{Version1}
+	encoded = protowire.AppendTag(encoded, field, protowire.BytesType)
+	return protowire.AppendBytes(encoded, body)
+}
+
+func TestDecodeRejects(t *testing.T) {
+	valid, err := Encode(&ValidateDataTx{DataFeed: "feed", DataValue: "1", DataTimestamp: 5})
+	if err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	withVersion := func(version byte) []byte {
+		return append([]byte{version}, valid[1:]...)
+	}
+	feed := protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), "feed")
+	timestamp := protowire.AppendTag(nil, 3, protowire.VarintType)
+
+	for name, test := range map[string]struct {
+		encoded []byte
+		err     error
+	}{
+		"empty":                        {nil, ErrUnsupportedVersion},
+		"version 0":                    {withVersion(0), ErrUnsupportedVersion},
+		"version 2":                    {withVersion(2), ErrUnsupportedVersion},
+		"unknown type":                 {testEncoding(3, nil), nil},
+		"two bodies":                   {append(testEncoding(fieldUnjail, nil), testEncoding(fieldSend, nil)[1:]...), nil},
+		"unknown field":                {testEncoding(fieldValidateData, append(append([]byte{}, feed...), protowire.AppendVarint(protowire.AppendTag(nil, 4, protowire.VarintType), 1)...)), nil},
+		"wrong wire type":              {testEncoding(fieldValidateData, protowire.AppendVarint(protowire.AppendTag(nil, 1, protowire.VarintType), 1)), nil},
+		"repeated field":               {testEncoding(fieldValidateData, append(append([]byte{}, feed...), feed...)), nil},
+		"non canonical varint":         {testEncoding(fieldValidateData, append(append(append([]byte{}, feed...), timestamp...), 0x85, 0x00)), ErrNonCanonical},
+		"fields out of order":          {testEncoding(fieldValidateData, append(protowire.AppendVarint(append([]byte{}, timestamp...), 5), feed...)), ErrNonCanonical},
+		"default value":                {testEncoding(fieldValidateData, protowire.AppendVarint(append(append([]byte{}, feed...), timestamp...), 0)), ErrNonCanonical},
+		"amount leading zero":          {testEncoding(fieldUnjail, protowire.AppendBytes(protowire.AppendTag(nil, 3, protowire.BytesType), []byte{0, 1})), ErrNonCanonical},
+		"bool 2":                       {testEncoding(fieldStakeTokens, protowire.AppendVarint(protowire.AppendTag(nil, 3, protowire.VarintType), 2)), ErrNonCanonical},
+		"oversize field":               {testEncoding(fieldValidateData, protowire.AppendString(protowire.AppendTag(nil, 1, protowire.BytesType), strings.Repeat("a", MaxFieldSize+1))), ErrTooLarge},
+		"oversize transaction":         {testEncoding(fieldValidateData, protowire.AppendString(protowire.AppendTag(nil, 9, protowire.BytesType), strings.Repeat("a", MaxTxSize))), ErrTooLarge},
+		"create validator account key": {testEncoding(fieldCreateValidator, protowire.AppendBytes(protowire.AppendTag(nil, 7, protowire.BytesType), []byte{1})), nil},
+	} {
+		tx, err := Decode(test.encoded)
+		if err == nil {
+			t.Errorf("%v: decoded %+v", name, tx)
+			continue
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%v: %v, expected %v", name, err, test.err)
+		}
+	}
+}
+
+func TestSignDocVector(t *testing.T) {
+	vector := loadEncodingVectors(t).SignDoc
+	fee, err := u256.Parse(vector.Fee)
+	if err != nil {
+		t.Fatalf("parsing fee: %v", err)
+	}
+	body, err := hex.DecodeString(vector.Body)
+	if err != nil {
+		t.Fatalf("decoding body: %v", err)
+	}
+	doc := &SignDoc{ChainID: vector.ChainID, AccountNumber: vector.AccountNumber, Nonce: vector.Nonce, Fee: fee, Memo: vector.Memo, Type: vector.Type, Body: body}
+	if signBytes := hex.EncodeToString(doc.SignBytes()); signBytes != vector.SignBytes {
+		t.Errorf("sign bytes %v, expected %v", signBytes, vector.SignBytes)
+	}
+
+	// The body is the send transaction the SignDoc was made for, without its signature
+	tx := &SendTx{FromAddress: "sender", ToAddress: "receiver", Amount: u256.New(5), Auth: Auth{Signature: []byte{1}, Fee: fee, Memo: vector.Memo, PubKey: []byte{2}}}
+	fromTx, err := SignDocOf(vector.ChainID, vector.AccountNumber, vector.Nonce, tx)
+	if err != nil {
+		t.Fatalf("creating sign doc: %v", err)
+	}
+	if !reflect.DeepEqual(fromTx, doc) {
+		t.Errorf("sign doc of the send transaction %+v, expected %+v", fromTx, doc)
+	}
+}
+
+func TestValidateDataVector(t *testing.T) {
+	vector := loadEncodingVectors(t).ValidateData
+	encoded, err := Encode(&ValidateDataTx{DataFeed: vector.DataFeed, DataValue: vector.DataValue, DataTimestamp: vector.DataTimestamp})
+	if err != nil {
+		t.Fatalf("encoding: %v", err)
+	}
+	if hex.EncodeToString(encoded) != vector.Encoded {
+		t.Errorf("encoded %x, expected %v", encoded, vector.Encoded)
+	}
+}
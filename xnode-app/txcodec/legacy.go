@@ -0,0 +1,116 @@
+package txcodec
+
+import (
+	"encoding/json"
+	"errors"
+
+	"tendermint-app/u256"
+)
+
+// Legacy JSON encoding, the transaction fields next to a TransactionType
+// Only accepted while clients migrate to the binary encoding
+// Legacy amounts are int64 with 9 decimals, they are converted to the 18 decimals of the binary encoding
+type LegacyTransaction struct {
+	TransactionType uint8
+}
+
+const legacyAmountFactor = 1_000_000_000
+
+type legacyAuth struct {
+	Signature []byte
+	Fee       int64
+	Memo      string
+	PubKey    []byte
+}
+
+type legacyStakeTokensTx struct {
+	Amount           int64 // Negative amount to unstake
+	ValidatorAddress string
+	legacyAuth
+}
+
+type legacyWithdrawTokensTx struct {
+	Amount           int64
+	Address          string
+	ValidatorAddress string
+	legacyAuth
+}
+
+// IsLegacyJSON reports whether a transaction uses the legacy JSON encoding instead of a versioned one
+func IsLegacyJSON(encoded []byte) bool {
+	return len(encoded) > 0 && encoded[0] == '{'
+}
+
+// DecodeLegacyJSON decodes a transaction in the legacy JSON encoding
+func DecodeLegacyJSON(encoded []byte) (Tx, error) {
+	if len(encoded) > MaxTxSize {
+		return nil, ErrTooLarge
+	}
+	transaction := &LegacyTransaction{}
+	if err := json.Unmarshal(encoded, transaction); err != nil {
+		return nil, err
+	}
+
+	switch transaction.TransactionType {
+	case TypeValidateData:
+		tx := &ValidateDataTx{}
+		if err := json.Unmarshal(encoded, tx); err != nil {
+			return nil, err
+		}
+		return tx, nil
+	case TypeClaimTokens:
+		tx := &ClaimTokensTx{}
+		if err := json.Unmarshal(encoded, tx); err != nil {
+			return nil, err
+		}
+		return tx, nil
+	case TypeStakeTokens:
+		legacy := &legacyStakeTokensTx{}
+		if err := json.Unmarshal(encoded, legacy); err != nil {
+			return nil, err
+		}
+		tx := &StakeTokensTx{ValidatorAddress: legacy.ValidatorAddress, Unstake: legacy.Amount < 0}
+		amount := legacy.Amount
+		if tx.Unstake {
+			amount = -amount
+		}
+		var err error
+		if tx.Amount, err = legacyAmount(amount); err != nil {
+			return nil, err
+		}
+		if tx.Auth, err = legacy.auth(); err != nil {
+			return nil, err
+		}
+		return tx, nil
+	case TypeWithdrawTokens:
+		legacy := &legacyWithdrawTokensTx{}
+		if err := json.Unmarshal(encoded, legacy); err != nil {
+			return nil, err
+		}
+		tx := &WithdrawTokensTx{Address: legacy.Address, ValidatorAddress: legacy.ValidatorAddress}
+		var err error
+		if tx.Amount, err = legacyAmount(legacy.Amount); err != nil {
+			return nil, err
+		}
+		if tx.Auth, err = legacy.auth(); err != nil {
+			return nil, err
+		}
+		return tx, nil
+	}
+	return nil, ErrUnknownType // Attestations are only created by up to date proposers
+}
+
+func legacyAmount(amount int64) (u256.Int, error) {
+	if amount < 0 {
+		return u256.Int{}, errors.New("negative amount")
+	}
+	return u256.New(uint64(amount)).Mul(u256.New(legacyAmountFactor))
+}
+
+func (legacy legacyAuth) auth() (Auth, error) {
+	fee, err := legacyAmount(legacy.Fee)
+	if err != nil {
+		return Auth{}, err
+	}
+	return Auth{Signature: legacy.Signature, Fee: fee, Memo: legacy.Memo, PubKey: legacy.PubKey}, nil
+}
@@ -0,0 +1,167 @@
+package txcodec
+
+import (
+	"fmt"
+
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"tendermint-app/u256"
+)
+
+// Signing
+// Transactions of an account are signed over a SignDoc, binding the signature to the chain, the account and its nonce
+// The sign bytes start with a domain separator, so they can never be mistaken for a transaction or a signature of another protocol
+var signDocDomain = []byte("xnode-app/SignDoc/v1:")
+
+// Fields shared by all transactions that are signed by an account
+type Auth struct {
+	Signature []byte   // Signature of the signer over the SignBytes of the transaction
+	Fee       u256.Int // Tokens paid to the block proposer
+	Memo      string
+	PubKey    []byte // Ed25519 key of the signer, only needed until the account has a public key
+}
+
+// SignedTx is a transaction that is authorized by the signature of an account
+type SignedTx interface {
+	Tx
+	Signer() string // Address of the signing account
+	Authentication() *Auth
+}
+
+func (tx *StakeTokensTx) Signer() string     { return tx.ValidatorAddress }
+func (tx *WithdrawTokensTx) Signer() string  { return tx.ValidatorAddress }
+func (tx *DelegateTx) Signer() string        { return tx.DelegatorAddress }
+func (tx *UndelegateTx) Signer() string      { return tx.DelegatorAddress }
+func (tx *UnjailTx) Signer() string          { return tx.ValidatorAddress }
+func (tx *WithdrawRewardsTx) Signer() string { return tx.Address }
+func (tx *SendTx) Signer() string            { return tx.FromAddress }
+func (tx *CreateValidatorTx) Signer() string {
+	return ed25519.PubKey(tx.PubKey).Address().String()
+}
+
+func (tx *StakeTokensTx) Authentication() *Auth     { return &tx.Auth }
+func (tx *WithdrawTokensTx) Authentication() *Auth  { return &tx.Auth }
+func (tx *CreateValidatorTx) Authentication() *Auth { return &tx.Auth }
+func (tx *DelegateTx) Authentication() *Auth        { return &tx.Auth }
+func (tx *UndelegateTx) Authentication() *Auth      { return &tx.Auth }
+func (tx *UnjailTx) Authentication() *Auth          { return &tx.Auth }
+func (tx *WithdrawRewardsTx) Authentication() *Auth { return &tx.Auth }
+func (tx *SendTx) Authentication() *Auth            { return &tx.Auth }
+
+// Everything a signature commits to
+type SignDoc struct {
+	ChainID       string
+	AccountNumber uint64 // Assigned when the account is created, so a recreated account can not replay old signatures
+	Nonce         uint64
+	Fee           u256.Int
+	Memo          string
+	Type          uint8
+	Body          []byte // Encoded transaction without signature
+}
+
+// SignDocOf returns the SignDoc of a transaction for the given state of its signer
+func SignDocOf(chainID string, accountNumber uint64, nonce uint64, tx SignedTx) (*SignDoc, error) {
+	unsigned, err := withoutSignature(tx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := Encode(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	auth := tx.Authentication()
+	return &SignDoc{
+		ChainID:       chainID,
+		AccountNumber: accountNumber,
+		Nonce:         nonce,
+		Fee:           auth.Fee,
+		Memo:          auth.Memo,
+		Type:          tx.Type(),
+		Body:          body,
+	}, nil
+}
+
+// SignBytes returns the bytes that have to be signed, the canonical encoding of the SignDoc message (see tx.proto) after the domain separator
+func (doc *SignDoc) SignBytes() []byte {
+	signBytes := append([]byte{}, signDocDomain...)
+	signBytes = appendString(signBytes, 1, doc.ChainID)
+	signBytes = appendUint(signBytes, 2, doc.AccountNumber)
+	signBytes = appendUint(signBytes, 3, doc.Nonce)
+	signBytes = appendAmount(signBytes, 4, doc.Fee)
+	signBytes = appendString(signBytes, 5, doc.Memo)
+	signBytes = appendUint(signBytes, 6, uint64(doc.Type))
+	return appendBytes(signBytes, 7, doc.Body)
+}
+
+func withoutSignature(tx SignedTx) (SignedTx, error) {
+	switch tx := tx.(type) {
+	case *StakeTokensTx:
+		unsigned := *tx
+		unsigned.Signature = nil
+		return &unsigned, nil
+	case *WithdrawTokensTx:
+		unsigned := *tx
+		unsigned.Signature = nil
+		return &unsigned, nil
+	case *CreateValidatorTx:
+		unsigned := *tx
+		unsigned.Signature = nil
+		return &unsigned, nil
+	case *DelegateTx:
+		unsigned := *tx
+		unsigned.Signature = nil
+		return &unsigned, nil
+	case *UndelegateTx:
+		unsigned := *tx
+		unsigned.Signature = nil
+		return &unsigned, nil
+	case *UnjailTx:
+		unsigned := *tx
+		unsigned.Signature = nil
+		return &unsigned, nil
+	case *WithdrawRewardsTx:
+		unsigned := *tx
+		unsigned.Signature = nil
+		return &unsigned, nil
+	case *SendTx:
+		unsigned := *tx
+		unsigned.Signature = nil
+		return &unsigned, nil
+	}
+	return nil, ErrUnknownType
+}
+
+// Auth fields are the last fields of a signed transaction: signature, fee, memo and public key starting at field number start
+func appendAuth(b []byte, start protowire.Number, auth Auth) []byte {
+	b = appendBytes(b, start, auth.Signature)
+	b = appendAmount(b, start+1, auth.Fee)
+	b = appendString(b, start+2, auth.Memo)
+	return appendBytes(b, start+3, auth.PubKey)
+}
+
+func authSchema(start protowire.Number, schema map[protowire.Number]protowire.Type) map[protowire.Number]protowire.Type {
+	schema[start] = protowire.BytesType
+	schema[start+1] = protowire.BytesType
+	schema[start+2] = protowire.BytesType
+	schema[start+3] = protowire.BytesType
+	return schema
+}
+
+func authFields(fields map[protowire.Number]fieldValue, start protowire.Number) (Auth, error) {
+	auth := Auth{}
+	var err error
+	if auth.Fee, err = amountField(fields[start+1]); err != nil {
+		return Auth{}, fmt.Errorf("fee: %w", err)
+	}
+	if auth.Signature, err = bytesField(fields[start]); err != nil {
+		return Auth{}, fmt.Errorf("signature: %w", err)
+	}
+	if auth.PubKey, err = bytesField(fields[start+3]); err != nil {
+		return Auth{}, fmt.Errorf("public key: %w", err)
+	}
+	if auth.Memo, err = stringField(fields[start+2], true); err != nil {
+		return Auth{}, fmt.Errorf("memo: %w", err)
+	}
+	return auth, nil
+}
@@ -0,0 +1,195 @@
+// Package u256 implements the unsigned 256-bit integers token amounts are stored in
+//
+// Amounts have the 18 decimals of OPEN on Ethereum, so every ERC20 amount can be bridged in and out without losing precision
+// Arithmetic never wraps around: operations that would overflow or underflow return an error instead
+// Int is a value type, copying it copies the amount and two amounts are equal if they are ==
+package u256
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/holiman/uint256"
+)
+
+var (
+	ErrOverflow       = errors.New("uint256 overflow")
+	ErrUnderflow      = errors.New("uint256 underflow")
+	ErrDivisionByZero = errors.New("uint256 division by zero")
+	ErrInvalid        = errors.New("invalid uint256")
+)
+
+// Int is an unsigned 256-bit integer, the zero value is 0
+type Int uint256.Int
+
+// New returns the Int of a uint64
+func New(value uint64) Int {
+	return Int(*uint256.NewInt(value))
+}
+
+// Parse parses a decimal Int: digits only, without sign or leading zeros
+func Parse(decimal string) (Int, error) {
+	if decimal == "" || (decimal[0] == '0' && len(decimal) > 1) {
+		return Int{}, fmt.Errorf("%w: %q is not a canonical decimal", ErrInvalid, decimal)
+	}
+	for _, c := range decimal {
+		if c < '0' || c > '9' {
+			return Int{}, fmt.Errorf("%w: %q is not a canonical decimal", ErrInvalid, decimal)
+		}
+	}
+	value := uint256.Int{}
+	if err := value.SetFromDecimal(decimal); err != nil {
+		return Int{}, ErrOverflow
+	}
+	return Int(value), nil
+}
+
+// FromBytes returns the Int of a big-endian byte slice of at most 32 bytes
+func FromBytes(b []byte) (Int, error) {
+	if len(b) > 32 {
+		return Int{}, ErrOverflow
+	}
+	return Int(*new(uint256.Int).SetBytes(b)), nil
+}
+
+// FromBig returns the Int of a big.Int, which should not be negative and fit in 256 bits
+func FromBig(b *big.Int) (Int, error) {
+	if b.Sign() < 0 {
+		return Int{}, ErrUnderflow
+	}
+	value, overflow := uint256.FromBig(b)
+	if overflow {
+		return Int{}, ErrOverflow
+	}
+	return Int(*value), nil
+}
+
+// Must returns the result of an operation that can not fail because of earlier checks, it panics if it does
+// A panic during block execution halts the chain, which is better than continuing with a balance that wrapped around
+func Must(x Int, err error) Int {
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+func (x *Int) int() *uint256.Int {
+	return (*uint256.Int)(x)
+}
+
+func (x Int) IsZero() bool {
+	return x.int().IsZero()
+}
+
+// Cmp returns -1 if x < y, 0 if x == y and 1 if x > y
+func (x Int) Cmp(y Int) int {
+	return x.int().Cmp(y.int())
+}
+
+func (x Int) Lt(y Int) bool {
+	return x.int().Lt(y.int())
+}
+
+func (x Int) Gt(y Int) bool {
+	return x.int().Gt(y.int())
+}
+
+// Min returns the smaller of x and y
+func (x Int) Min(y Int) Int {
+	if y.Lt(x) {
+		return y
+	}
+	return x
+}
+
+// Add returns x + y
+func (x Int) Add(y Int) (Int, error) {
+	sum, overflow := new(uint256.Int).AddOverflow(x.int(), y.int())
+	if overflow {
+		return Int{}, ErrOverflow
+	}
+	return Int(*sum), nil
+}
+
+// Sub returns x - y
+func (x Int) Sub(y Int) (Int, error) {
+	difference, underflow := new(uint256.Int).SubOverflow(x.int(), y.int())
+	if underflow {
+		return Int{}, ErrUnderflow
+	}
+	return Int(*difference), nil
+}
+
+// Mul returns x * y
+func (x Int) Mul(y Int) (Int, error) {
+	product, overflow := new(uint256.Int).MulOverflow(x.int(), y.int())
+	if overflow {
+		return Int{}, ErrOverflow
+	}
+	return Int(*product), nil
+}
+
+// Div returns x / y, rounded down
+func (x Int) Div(y Int) (Int, error) {
+	if y.IsZero() {
+		return Int{}, ErrDivisionByZero
+	}
+	return Int(*new(uint256.Int).Div(x.int(), y.int())), nil
+}
+
+// MulDiv returns x * y / z, the product has 512 bits so only the quotient has to fit
+func (x Int) MulDiv(y Int, z Int, roundUp bool) (Int, error) {
+	if z.IsZero() {
+		return Int{}, ErrDivisionByZero
+	}
+	quotient, overflow := new(uint256.Int).MulDivOverflow(x.int(), y.int(), z.int())
+	if overflow {
+		return Int{}, ErrOverflow
+	}
+	if roundUp && !new(uint256.Int).MulMod(x.int(), y.int(), z.int()).IsZero() {
+		return Int(*quotient).Add(New(1))
+	}
+	return Int(*quotient), nil
+}
+
+// Uint64 returns x as a uint64, false if it does not fit
+func (x Int) Uint64() (uint64, bool) {
+	return x.int().Uint64(), x.int().IsUint64()
+}
+
+func (x Int) Big() *big.Int {
+	return x.int().ToBig()
+}
+
+// Bytes returns the big-endian bytes of x without leading zeros, so 0 has no bytes
+func (x Int) Bytes() []byte {
+	return x.int().Bytes()
+}
+
+// String returns x in decimal
+func (x Int) String() string {
+	return x.int().Dec()
+}
+
+// MarshalJSON encodes x as a decimal string, as JSON numbers lose precision in most decoders
+func (x Int) MarshalJSON() ([]byte, error) {
+	return json.Marshal(x.String())
+}
+
+// UnmarshalJSON accepts a decimal string or a JSON number without fraction or exponent
+func (x *Int) UnmarshalJSON(data []byte) error {
+	decimal := string(data)
+	if len(data) > 0 && data[0] == '"' {
+		if err := json.Unmarshal(data, &decimal); err != nil {
+			return err
+		}
+	}
+	value, err := Parse(decimal)
+	if err != nil {
+		return err
+	}
+	*x = value
+	return nil
+}
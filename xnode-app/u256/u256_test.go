@@ -0,0 +1,96 @@
+package u256
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+const maxDecimal = "115792089237316195423570985008687907853269984665640564039457584007913129639935" // 2^256 - 1
+
+func TestParse(t *testing.T) {
+	for _, decimal := range []string{"0", "1", "1000000000000000000", maxDecimal} {
+		value, err := Parse(decimal)
+		if err != nil {
+			t.Errorf("parsing %v: %v", decimal, err)
+			continue
+		}
+		if value.String() != decimal {
+			t.Errorf("parsing %v: got %v", decimal, value)
+		}
+	}
+
+	for _, decimal := range []string{"", "01", "+1", "-1", "1.5", "1e18", " 1", "0x10"} {
+		if _, err := Parse(decimal); !errors.Is(err, ErrInvalid) {
+			t.Errorf("parsing %q: expected ErrInvalid, got %v", decimal, err)
+		}
+	}
+	if _, err := Parse(maxDecimal[:len(maxDecimal)-1] + "6"); !errors.Is(err, ErrOverflow) {
+		t.Errorf("parsing 2^256: expected ErrOverflow, got %v", err)
+	}
+}
+
+func TestArithmeticDoesNotWrap(t *testing.T) {
+	max := Must(Parse(maxDecimal))
+	if _, err := max.Add(New(1)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("2^256 - 1 + 1: expected ErrOverflow, got %v", err)
+	}
+	if _, err := New(1).Sub(New(2)); !errors.Is(err, ErrUnderflow) {
+		t.Errorf("1 - 2: expected ErrUnderflow, got %v", err)
+	}
+	if _, err := max.Mul(New(2)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("(2^256 - 1) * 2: expected ErrOverflow, got %v", err)
+	}
+	if _, err := New(1).Div(Int{}); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("1 / 0: expected ErrDivisionByZero, got %v", err)
+	}
+
+	// The intermediate product does not have to fit
+	expected := new(big.Int).Div(new(big.Int).Mul(max.Big(), big.NewInt(3)), big.NewInt(4))
+	if value, err := max.MulDiv(New(3), New(4), false); err != nil || value.Big().Cmp(expected) != 0 {
+		t.Errorf("(2^256 - 1) * 3 / 4: got %v, %v", value, err)
+	}
+	if _, err := max.MulDiv(New(2), New(1), false); !errors.Is(err, ErrOverflow) {
+		t.Errorf("(2^256 - 1) * 2 / 1: expected ErrOverflow, got %v", err)
+	}
+	if value, err := New(7).MulDiv(New(1), New(2), true); err != nil || value != New(4) {
+		t.Errorf("7 / 2 rounded up: got %v, %v", value, err)
+	}
+	if value, err := New(8).MulDiv(New(1), New(2), true); err != nil || value != New(4) {
+		t.Errorf("8 / 2 rounded up: got %v, %v", value, err)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	if len(New(0).Bytes()) != 0 {
+		t.Errorf("0 should have no bytes, got %x", New(0).Bytes())
+	}
+	if value, err := FromBytes(New(256).Bytes()); err != nil || value != New(256) {
+		t.Errorf("256 round trip: got %v, %v", value, err)
+	}
+	if _, err := FromBytes(make([]byte, 33)); !errors.Is(err, ErrOverflow) {
+		t.Errorf("33 bytes: expected ErrOverflow, got %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type account struct{ Tokens Int }
+	encoded, err := json.Marshal(account{Tokens: Must(Parse(maxDecimal))})
+	if err != nil || string(encoded) != `{"Tokens":"`+maxDecimal+`"}` {
+		t.Fatalf("encoding: got %s, %v", encoded, err)
+	}
+
+	decoded := account{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil || decoded.Tokens.String() != maxDecimal {
+		t.Errorf("decoding string: got %v, %v", decoded.Tokens, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Tokens":1000000000000000000}`), &decoded); err != nil || decoded.Tokens != New(1_000_000_000_000_000_000) {
+		t.Errorf("decoding number: got %v, %v", decoded.Tokens, err)
+	}
+	for _, invalid := range []string{`{"Tokens":-1}`, `{"Tokens":1.5}`, `{"Tokens":"1e18"}`, `{"Tokens":null}`} {
+		if err := json.Unmarshal([]byte(invalid), &decoded); err == nil {
+			t.Errorf("decoding %s should fail", invalid)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/cometbft/cometbft/abci/types"
+	"github.com/cometbft/cometbft/crypto/ed25519"
+	cmttypes "github.com/cometbft/cometbft/types"
+
+	"tendermint-app/u256"
+)
+
+// Validator set
+// At the end of every block the Params.MaxValidators validators with the most stake form the active set
+// Their consensus power is their stake divided by Params.PowerReduction, capped so the total stays below CometBFT's MaxTotalVotingPower
+// Only the difference with the previous active set is sent to CometBFT
+
+// Consensus power of an amount of staked tokens
+func (params Params) consensusPower(tokens u256.Int) int64 {
+	maxPower := cmttypes.MaxTotalVotingPower / params.MaxValidators
+	power, fits := u256.Must(tokens.Div(params.PowerReduction)).Uint64()
+	if !fits || power > uint64(maxPower) {
+		return maxPower
+	}
+	return int64(power)
+}
+
+// Staked tokens of an amount of consensus power, as reported by CometBFT in commits and evidence
+func (params Params) powerTokens(power int64) u256.Int {
+	return u256.Must(u256.New(uint64(power)).Mul(params.PowerReduction)) // Powers are at most MaxTotalVotingPower, validate checked that it fits
+}
+
+// Active set as it should be after this block, validator address -> consensus power
+func (app *Application) nextActiveSet() map[string]int64 {
+	candidates := make([]string, 0)
+	for address, validator := range app.Validators {
+		if len(validator.PubKey) != ed25519.PubKeySize {
+			continue // Without a consensus key CometBFT rejects the update and halts
+		}
+		if !validator.Jailed && !validator.GovernancePower.Lt(minimumValidatorPower) && app.Params.consensusPower(validator.GovernancePower) > 0 {
+			candidates = append(candidates, address)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		powerI, powerJ := app.Validators[candidates[i]].GovernancePower, app.Validators[candidates[j]].GovernancePower
+		if powerI != powerJ {
+			return powerI.Gt(powerJ)
+		}
+		return candidates[i] < candidates[j]
+	})
+	if int64(len(candidates)) > app.Params.MaxValidators {
+		candidates = candidates[:app.Params.MaxValidators]
+	}
+
+	activeSet := make(map[string]int64, len(candidates))
+	for _, address := range candidates {
+		activeSet[address] = app.Params.consensusPower(app.Validators[address].GovernancePower)
+	}
+	return activeSet
+}
+
+// Replaces the active set, returns the validator updates for CometBFT sorted by address
+func (app *Application) updateActiveSet() []types.ValidatorUpdate {
+	activeSet := app.nextActiveSet()
+
+	changed := make([]string, 0)
+	for address, power := range activeSet {
+		if app.ActiveSet[address] != power {
+			changed = append(changed, address)
+		}
+	}
+	for address := range app.ActiveSet {
+		if _, active := activeSet[address]; !active {
+			changed = append(changed, address) // Removed with power 0
+		}
+	}
+	sort.Strings(changed)
+
+	validatorUpdates := make([]types.ValidatorUpdate, len(changed))
+	for i, address := range changed {
+		validatorUpdates[i] = types.Ed25519ValidatorUpdate(app.Validators[address].PubKey.Bytes(), activeSet[address])
+		app.markDirty(activeSetPrefix + address)
+	}
+	app.ActiveSet = activeSet
+	return validatorUpdates
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/cometbft/cometbft/abci/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	eth "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+
+	"tendermint-app/u256"
+)
+
+// Withdrawals
+// A WithdrawTokensTx burns unstaked tokens and records a withdrawal to an Ethereum address
+// The withdrawals of an address are numbered like the withdraw nonce of the OpenWithdrawing contract, which only accepts them in that order
+// The contract mints the tokens for an EIP-712 signature of its owner over Withdraw(address withdrawer,uint256 nonce,uint256 amount)
+// in the OpenStaking version 1 domain. A node with the owner key signs these vouchers when they are queried,
+// the signature is deterministic (RFC 6979) so every node with the key returns the same voucher
+// Tokens have the same 18 decimals as OPEN on Ethereum, so the voucher is for exactly the withdrawn amount
+const (
+	withdrawDomainName    = "OpenStaking"
+	withdrawDomainVersion = "1"
+)
+
+var withdrawTypes = apitypes.Types{
+	"EIP712Domain": {
+		{Name: "name", Type: "string"},
+		{Name: "version", Type: "string"},
+		{Name: "chainId", Type: "uint256"},
+		{Name: "verifyingContract", Type: "address"},
+	},
+	"Withdraw": {
+		{Name: "withdrawer", Type: "address"},
+		{Name: "nonce", Type: "uint256"},
+		{Name: "amount", Type: "uint256"},
+	},
+}
+
+// Tokens leaving the chain, the index in the withdrawals of the withdrawer is the nonce
+type Withdrawal struct {
+	Account string // Account the tokens were withdrawn from
+	Amount  u256.Int
+	Height  int64
+}
+
+// Arguments of OpenWithdrawing.withdraw(v, r, s, withdrawer, amount)
+type WithdrawalVoucher struct {
+	Withdrawer string
+	Nonce      uint64
+	Amount     u256.Int
+	V          uint8
+	R          string // 0x prefixed bytes32
+	S          string
+	Signer     string // Owner of the OpenWithdrawing contract
+}
+
+// Owner key of the OpenWithdrawing contract on Ethereum
+type withdrawalSigner struct {
+	key      *ecdsa.PrivateKey
+	chainID  *big.Int
+	contract common.Address
+}
+
+// Records a withdrawal of unstaked tokens, returns its nonce
+func (app *Application) withdraw(accountAddress string, withdrawer string, amount u256.Int, block *blockContext) uint64 {
+	account := app.Accounts[accountAddress]
+	account.Tokens = u256.Must(account.Tokens.Sub(amount))
+	app.Accounts[accountAddress] = account
+	app.markDirty(accountPrefix + accountAddress)
+
+	app.Withdrawals[withdrawer] = append(app.Withdrawals[withdrawer], Withdrawal{Account: accountAddress, Amount: amount, Height: block.Height})
+	app.markDirty(withdrawalPrefix + withdrawer)
+	return uint64(len(app.Withdrawals[withdrawer]) - 1)
+}
+
+// EIP-712 hash the OpenWithdrawing contract recovers the owner from
+func (signer *withdrawalSigner) hash(withdrawer string, nonce uint64, amount *big.Int) ([]byte, error) {
+	hash, _, err := apitypes.TypedDataAndHash(apitypes.TypedData{
+		Types:       withdrawTypes,
+		PrimaryType: "Withdraw",
+		Domain: apitypes.TypedDataDomain{
+			Name:              withdrawDomainName,
+			Version:           withdrawDomainVersion,
+			ChainId:           (*math.HexOrDecimal256)(signer.chainID),
+			VerifyingContract: signer.contract.Hex(),
+		},
+		Message: apitypes.TypedDataMessage{
+			"withdrawer": withdrawer,
+			"nonce":      strconv.FormatUint(nonce, 10),
+			"amount":     amount.String(),
+		},
+	})
+	return hash, err
+}
+
+func (signer *withdrawalSigner) voucher(withdrawer string, nonce uint64, withdrawal Withdrawal) (*WithdrawalVoucher, error) {
+	hash, err := signer.hash(withdrawer, nonce, withdrawal.Amount.Big())
+	if err != nil {
+		return nil, err
+	}
+	signature, err := eth.Sign(hash, signer.key)
+	if err != nil {
+		return nil, err
+	}
+	return &WithdrawalVoucher{
+		Withdrawer: withdrawer,
+		Nonce:      nonce,
+		Amount:     withdrawal.Amount,
+		V:          signature[64] + 27,
+		R:          hexutil.Encode(signature[:32]),
+		S:          hexutil.Encode(signature[32:64]),
+		Signer:     eth.PubkeyToAddress(signer.key.PublicKey).Hex(),
+	}, nil
+}
+
+// Signed voucher of a recorded withdrawal, argument is <withdrawer>/<nonce>
+func (app *Application) queryWithdrawalVoucher(argument string) *types.ResponseQuery {
+	if app.WithdrawalSigner == nil {
+		return app.queryError(CodeTypeInvalidQuery, "This node can not sign withdrawals, query a node with the withdrawal signer key")
+	}
+	withdrawer, rawNonce, _ := strings.Cut(argument, "/")
+	nonce, err := strconv.ParseUint(rawNonce, 10, 64)
+	if err != nil {
+		return app.queryError(CodeTypeInvalidQuery, fmt.Sprintf("Invalid withdrawal nonce %v", rawNonce))
+	}
+	withdrawals := []Withdrawal{}
+	if value, exists := app.committed[withdrawalPrefix+withdrawer]; exists {
+		if err := json.Unmarshal(value, &withdrawals); err != nil {
+			return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Decoding withdrawals of %v: %v", withdrawer, err))
+		}
+	}
+	if nonce >= uint64(len(withdrawals)) {
+		return app.queryError(CodeTypeNotFound, fmt.Sprintf("Withdrawal %d of %v not found", nonce, withdrawer))
+	}
+
+	voucher, err := app.WithdrawalSigner.voucher(withdrawer, nonce, withdrawals[nonce])
+	if err != nil {
+		return app.queryError(CodeTypeUnknownError, fmt.Sprintf("Signing withdrawal %d of %v: %v", nonce, withdrawer, err))
+	}
+	return app.queryResult([]byte(argument), voucher)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	eth "github.com/ethereum/go-ethereum/crypto"
+
+	"tendermint-app/u256"
+)
+
+// Owner of the OpenWithdrawing contract in smart-contracts/scripts/withdrawVectors.ts (hardhat account 0)
+const testWithdrawalSignerKey = "ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+
+// Test vectors in the format of smart-contracts/scripts/withdrawVectors.ts, run it to regenerate them
+type withdrawalVector struct {
+	ChainID    uint64 `json:"chainId"`
+	Contract   string `json:"contract"`
+	Withdrawer string `json:"withdrawer"`
+	Nonce      uint64 `json:"nonce"`
+	Amount     string `json:"amount"`
+	Digest     string `json:"digest"`
+	Signer     string `json:"signer"`
+	V          uint8  `json:"v"`
+	R          string `json:"r"`
+	S          string `json:"s"`
+}
+
+func loadWithdrawalVectors(t *testing.T) []withdrawalVector {
+	t.Helper()
+	encoded, err := os.ReadFile("testdata/withdrawal_vectors.json")
+	if err != nil {
+		t.Fatalf("reading withdrawal vectors: %v", err)
+	}
+	vectors := []withdrawalVector{}
+	if err := json.Unmarshal(encoded, &vectors); err != nil {
+		t.Fatalf("decoding withdrawal vectors: %v", err)
+	}
+	return vectors
+}
+
+// Vouchers are signed over the digest OpenWithdrawing.withdraw recovers the owner from, with the signature the scripts produced
+func TestWithdrawalVoucherVectors(t *testing.T) {
+	key, err := eth.HexToECDSA(testWithdrawalSignerKey)
+	if err != nil {
+		t.Fatalf("loading signer key: %v", err)
+	}
+	for _, vector := range loadWithdrawalVectors(t) {
+		signer := &withdrawalSigner{key: key, chainID: new(big.Int).SetUint64(vector.ChainID), contract: common.HexToAddress(vector.Contract)}
+		amount, err := u256.Parse(vector.Amount)
+		if err != nil {
+			t.Fatalf("parsing amount %v: %v", vector.Amount, err)
+		}
+
+		digest, err := signer.hash(vector.Withdrawer, vector.Nonce, amount.Big())
+		if err != nil {
+			t.Fatalf("hashing withdrawal %d of %v: %v", vector.Nonce, vector.Withdrawer, err)
+		}
+		if !bytes.Equal(digest, hexutil.MustDecode(vector.Digest)) {
+			t.Errorf("withdrawal %d of %v hashes to %x, expected %v", vector.Nonce, vector.Withdrawer, digest, vector.Digest)
+		}
+		if other, _ := signer.hash(vector.Withdrawer, vector.Nonce+1, amount.Big()); bytes.Equal(other, digest) {
+			t.Errorf("withdrawal %d of %v has the same hash for the next nonce", vector.Nonce, vector.Withdrawer)
+		}
+
+		voucher, err := signer.voucher(vector.Withdrawer, vector.Nonce, Withdrawal{Amount: amount})
+		if err != nil {
+			t.Fatalf("signing withdrawal %d of %v: %v", vector.Nonce, vector.Withdrawer, err)
+		}
+		if voucher.V != vector.V || voucher.R != vector.R || voucher.S != vector.S || voucher.Signer != vector.Signer {
+			t.Errorf("voucher of withdrawal %d of %v is %+v, expected %+v", vector.Nonce, vector.Withdrawer, voucher, vector)
+		}
+
+		// ECDSA.recover(digest, v, r, s) has to return the owner
+		signature := append(hexutil.MustDecode(voucher.R), hexutil.MustDecode(voucher.S)...)
+		publicKey, err := eth.SigToPub(hexutil.MustDecode(vector.Digest), append(signature, voucher.V-27))
+		if err != nil || eth.PubkeyToAddress(*publicKey) != common.HexToAddress(vector.Signer) {
+			t.Errorf("voucher of withdrawal %d of %v does not recover to the owner (error %v)", vector.Nonce, vector.Withdrawer, err)
+		}
+	}
+}